@@ -35,6 +35,12 @@ func ConfigureApp(config *pkgmirror.Config, l *goapp.Lifecycle) {
 		mux.HandleFuncC(pat.Get("/api/mirrors"), Api_GET_MirrorServices(app))
 		mux.HandleFunc(pat.Get("/api/sse"), Api_GET_Sse(app))
 		mux.HandleFuncC(pat.Get("/api/ping"), Api_GET_Ping(app))
+		mux.HandleFuncC(pat.Get("/api/git/:code/failures"), Api_GET_GitFailures(app))
+		mux.HandleFuncC(pat.Get("/api/git/:code/archives"), Api_GET_GitArchives(app))
+		mux.HandleFuncC(pat.Get("/api/git/:code/repositories"), Api_GET_GitRepositories(app))
+		mux.HandleFuncC(pat.Get("/api/git/:code/repositories/*"), Api_GET_GitRefLog(app))
+		mux.HandleFuncC(pat.Delete("/api/git/:code/repositories/*"), Api_DELETE_GitRepository(app))
+		mux.HandleFuncC(pat.Post("/api/git/:code/repositories/*"), Api_POST_GitRepositoryFetch(app))
 
 		return nil
 	})
@@ -6,11 +6,17 @@
 package api
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/rande/goapp"
 	"github.com/rande/pkgmirror"
+	"github.com/rande/pkgmirror/mirror/git"
+	"goji.io/pat"
+	"goji.io/pattern"
 	"golang.org/x/net/context"
 )
 
@@ -157,3 +163,216 @@ func Api_GET_Ping(app *goapp.App) func(ctx context.Context, w http.ResponseWrite
 		w.Write([]byte("pong"))
 	}
 }
+
+// getGitService returns the git service of the enabled mirror, or nil.
+func getGitService(app *goapp.App, config *pkgmirror.Config, code string) *git.GitService {
+	if conf, ok := config.Git[code]; !ok || !conf.Enabled {
+		return nil
+	}
+
+	return app.Get(fmt.Sprintf("pkgmirror.git.%s", code)).(*git.GitService)
+}
+
+// validApiToken checks the token of the request against the ApiToken of the
+// git mirror, the endpoints changing the mirror are disabled without a token.
+func validApiToken(config *pkgmirror.Config, code string, r *http.Request) bool {
+	token := config.Git[code].ApiToken
+
+	return len(token) > 0 && subtle.ConstantTimeCompare([]byte(pkgmirror.RequestToken(r)), []byte(token)) == 1
+}
+
+func Api_GET_GitFailures(app *goapp.App) func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	config := app.Get("config").(*pkgmirror.Config)
+
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		gitService := getGitService(app, config, pat.Param(ctx, "code"))
+
+		if gitService == nil {
+			pkgmirror.SendWithHttpCode(w, 404, pkgmirror.ResourceNotFoundError.Error())
+
+			return
+		}
+
+		repos, err := gitService.Repositories()
+		if err != nil {
+			pkgmirror.SendWithHttpCode(w, 500, err.Error())
+
+			return
+		}
+
+		d := []*git.Repository{}
+		for _, repo := range repos {
+			if repo.Failures > 0 {
+				d = append(d, repo)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		pkgmirror.Serialize(w, d)
+	}
+}
+
+func Api_GET_GitRepositories(app *goapp.App) func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	config := app.Get("config").(*pkgmirror.Config)
+
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		gitService := getGitService(app, config, pat.Param(ctx, "code"))
+
+		if gitService == nil {
+			pkgmirror.SendWithHttpCode(w, 404, pkgmirror.ResourceNotFoundError.Error())
+
+			return
+		}
+
+		page, err := strconv.Atoi(r.FormValue("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+
+		perPage, err := strconv.Atoi(r.FormValue("per_page"))
+		if err != nil || perPage < 1 || perPage > 500 {
+			perPage = 50
+		}
+
+		total, repos, err := gitService.Inventory(&git.InventoryFilter{
+			Query:  r.FormValue("q"),
+			Status: r.FormValue("status"),
+			Offset: (page - 1) * perPage,
+			Limit:  perPage,
+		})
+
+		if err != nil {
+			pkgmirror.SendWithHttpCode(w, 500, err.Error())
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		pkgmirror.Serialize(w, &GitRepositoryList{
+			Total:        total,
+			Page:         page,
+			PerPage:      perPage,
+			Repositories: repos,
+		})
+	}
+}
+
+func Api_DELETE_GitRepository(app *goapp.App) func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	config := app.Get("config").(*pkgmirror.Config)
+
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		gitService := getGitService(app, config, pat.Param(ctx, "code"))
+
+		if gitService == nil {
+			pkgmirror.SendWithHttpCode(w, 404, pkgmirror.ResourceNotFoundError.Error())
+
+			return
+		}
+
+		if !validApiToken(config, pat.Param(ctx, "code"), r) {
+			pkgmirror.SendWithHttpCode(w, 403, pkgmirror.InvalidTokenError.Error())
+
+			return
+		}
+
+		switch err := gitService.RemoveRepository(strings.TrimPrefix(pattern.Path(ctx), "/")); err {
+		case nil:
+			pkgmirror.SendWithHttpCode(w, 200, "Repository removed")
+		case pkgmirror.ResourceNotFoundError:
+			pkgmirror.SendWithHttpCode(w, 404, err.Error())
+		case pkgmirror.SyncInProgressError:
+			pkgmirror.SendWithHttpCode(w, 409, err.Error())
+		default:
+			pkgmirror.SendWithHttpCode(w, 500, err.Error())
+		}
+	}
+}
+
+func Api_POST_GitRepositoryFetch(app *goapp.App) func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	config := app.Get("config").(*pkgmirror.Config)
+
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		gitService := getGitService(app, config, pat.Param(ctx, "code"))
+		path := strings.TrimPrefix(pattern.Path(ctx), "/")
+
+		if gitService == nil || !strings.HasSuffix(path, "/fetch") {
+			pkgmirror.SendWithHttpCode(w, 404, pkgmirror.ResourceNotFoundError.Error())
+
+			return
+		}
+
+		if !validApiToken(config, pat.Param(ctx, "code"), r) {
+			pkgmirror.SendWithHttpCode(w, 403, pkgmirror.InvalidTokenError.Error())
+
+			return
+		}
+
+		path = strings.TrimSuffix(path, "/fetch")
+
+		if !gitService.IsRepository(path) {
+			pkgmirror.SendWithHttpCode(w, 404, pkgmirror.ResourceNotFoundError.Error())
+
+			return
+		}
+
+		if gitService.EnqueueFetch(path) {
+			pkgmirror.SendWithHttpCode(w, 202, "Fetch queued")
+		} else {
+			pkgmirror.SendWithHttpCode(w, 200, "Fetch already queued")
+		}
+	}
+}
+
+func Api_GET_GitRefLog(app *goapp.App) func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	config := app.Get("config").(*pkgmirror.Config)
+
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		gitService := getGitService(app, config, pat.Param(ctx, "code"))
+		path := strings.TrimPrefix(pattern.Path(ctx), "/")
+
+		if gitService == nil || !strings.HasSuffix(path, "/refs") {
+			pkgmirror.SendWithHttpCode(w, 404, pkgmirror.ResourceNotFoundError.Error())
+
+			return
+		}
+
+		path = strings.TrimSuffix(path, "/refs")
+
+		if !gitService.IsRepository(path) {
+			pkgmirror.SendWithHttpCode(w, 404, pkgmirror.ResourceNotFoundError.Error())
+
+			return
+		}
+
+		changes, err := gitService.RefLog(path)
+		if err != nil {
+			pkgmirror.SendWithHttpCode(w, 500, err.Error())
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		pkgmirror.Serialize(w, changes)
+	}
+}
+
+func Api_GET_GitArchives(app *goapp.App) func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	config := app.Get("config").(*pkgmirror.Config)
+
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		gitService := getGitService(app, config, pat.Param(ctx, "code"))
+
+		if gitService == nil {
+			pkgmirror.SendWithHttpCode(w, 404, pkgmirror.ResourceNotFoundError.Error())
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		pkgmirror.Serialize(w, gitService.ArchiveStats())
+	}
+}
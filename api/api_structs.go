@@ -5,6 +5,10 @@
 
 package api
 
+import (
+	"github.com/rande/pkgmirror/mirror/git"
+)
+
 type ServiceMirror struct {
 	Id        string
 	Type      string
@@ -15,3 +19,10 @@ type ServiceMirror struct {
 	Enabled   bool
 	Usage     string
 }
+
+type GitRepositoryList struct {
+	Total        int
+	Page         int
+	PerPage      int
+	Repositories []*git.RepositoryInfo
+}
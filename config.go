@@ -15,6 +15,10 @@ type BowerConfig struct {
 	Server  string
 	Enabled bool
 	Icon    string
+	Token   string // required to register or remove private packages if set
+
+	Prefetch         bool     // clone the git repositories of the packages after each sync
+	PrefetchPackages []string // glob patterns of the prefetched packages, all packages if empty
 }
 
 type NpmConfig struct {
@@ -26,11 +30,71 @@ type NpmConfig struct {
 	}
 }
 
-type GitConfig struct {
+type GitRewriteRule struct {
+	Type    string // archive or repository
+	Pattern string
 	Server  string
-	Enabled bool
-	Icon    string
-	Clone   string
+	Path    string
+	Ref     string
+}
+
+// GitPartialRule overrides the mirrored refs and the partial clone filter of
+// the repositories matching the glob pattern, ie: big/*.git
+type GitPartialRule struct {
+	Pattern string
+	Refs    []string
+	Filter  string
+}
+
+type GitConfig struct {
+	Server            string
+	Enabled           bool
+	Icon              string
+	Clone             string
+	FetchWorkers      int
+	FetchTimeout      int // in seconds
+	FetchBackoff      int // in seconds, maximum delay before retrying a failing repository
+	WebhookSecret     string
+	CloneWorkers      int
+	CloneTimeout      int // in seconds
+	ArchivePrefix     bool
+	Lfs               bool
+	Submodules        bool
+	ArchiveSubmodules bool
+	Username          string
+	Password          string
+	Token             string
+	ApiToken          string // required to remove or fetch repositories with the api, the endpoints are disabled if empty
+	SshKey            string // path to the private key
+	KnownHosts        string // path to the known_hosts file
+	CloneAllow        []string
+	CloneDeny         []string
+	CloneMaxSize      int64 // in MB
+	CloneRateLimit    int   // clones per client and per hour
+	Prune             bool
+	ImmutableTags     bool
+	GcInterval        int    // in seconds
+	UnusedAfter       int    // in days
+	UnusedAction      string // skip, archive or remove
+	FetchMinInterval  int    // in seconds
+	FetchMaxInterval  int    // in seconds
+	Rewrites          []*GitRewriteRule
+	Type              string // git (default) or svn
+	SvnLayout         string // std (default) or none
+	Backend           string // binary (default) or go-git
+	ArchiveWorkers    int
+	ArchiveQueueSize  int      // -1 for no limit
+	Refs              []string // ref patterns to mirror, ie: refs/tags/*
+	Filter            string   // partial clone filter, ie: blob:none
+	Partial           []*GitPartialRule
+}
+
+// GitSshConfig configures the read-only ssh endpoint serving the git mirrors.
+type GitSshConfig struct {
+	Enabled        bool
+	Listen         string // ie: :2222
+	HostKey        string // path to the private host key
+	AuthorizedKeys string // path to the authorized_keys file of the clients
 }
 
 type StaticConfig struct {
@@ -49,6 +113,7 @@ type Config struct {
 	Composer       map[string]*ComposerConfig
 	Npm            map[string]*NpmConfig
 	Git            map[string]*GitConfig
+	GitSsh         *GitSshConfig
 	Bower          map[string]*BowerConfig
 	Static         map[string]*StaticConfig
 }
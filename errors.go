@@ -10,11 +10,20 @@ import (
 )
 
 var (
-	SyncInProgressError   = errors.New("A synchronization is already running")
-	EmptyKeyError         = errors.New("No value available")
-	ResourceNotFoundError = errors.New("Resource not found")
-	EmptyDataError        = errors.New("Empty data")
-	SameKeyError          = errors.New("Same key")
-	HttpError             = errors.New("Http error")
-	InvalidPackageError   = errors.New("Invalid package error")
+	SyncInProgressError       = errors.New("A synchronization is already running")
+	EmptyKeyError             = errors.New("No value available")
+	ResourceNotFoundError     = errors.New("Resource not found")
+	EmptyDataError            = errors.New("Empty data")
+	SameKeyError              = errors.New("Same key")
+	HttpError                 = errors.New("Http error")
+	InvalidPackageError       = errors.New("Invalid package error")
+	CommandTimeoutError       = errors.New("Command timed out")
+	InvalidArchiveFormatError = errors.New("Invalid archive format")
+	CloneForbiddenError       = errors.New("The repository is not allowed to be cloned")
+	RepositoryTooLargeError   = errors.New("The repository exceeds the maximum size")
+	RateLimitError            = errors.New("Too many requests, please retry later")
+	ArchiveQueueFullError     = errors.New("Too many archives in progress, please retry later")
+	PackageExistsError        = errors.New("The package already exists")
+	InvalidTokenError         = errors.New("Invalid or missing token")
+	MissingRemoteUrlError     = errors.New("The remote has no url")
 )
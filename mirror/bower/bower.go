@@ -6,9 +6,13 @@
 package bower
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -18,11 +22,18 @@ import (
 	"github.com/rande/pkgmirror/mirror/git"
 )
 
+// syncBatchSize is the number of packages saved in a single transaction.
+const syncBatchSize = 1000
+
 type BowerConfig struct {
 	SourceServer string
 	PublicServer string
 	Code         []byte
 	Path         string
+	Token        string
+
+	Prefetch         bool
+	PrefetchPackages []string
 }
 
 func NewBowerService() *BowerService {
@@ -32,6 +43,7 @@ func NewBowerService() *BowerService {
 			Code:         []byte("bower"),
 			Path:         "./data/bower",
 		},
+		Rewriter: git.NewRewriter(),
 	}
 }
 
@@ -41,6 +53,10 @@ type BowerService struct {
 	Logger    *log.Entry
 	lock      bool
 	StateChan chan pkgmirror.State
+	Rewriter  *git.Rewriter
+	Mirrors   func(server string) *git.GitService // returns the enabled git mirror of a server, or nil
+
+	prefetching int32
 }
 
 func (bs *BowerService) Init(app *goapp.App) (err error) {
@@ -53,8 +69,16 @@ func (bs *BowerService) Init(app *goapp.App) (err error) {
 			"bucket": string(bs.Config.Code),
 			"action": "Init",
 		}).Error("Unable to open the internal database")
+
+		return
 	}
 
+	err = bs.DB.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bs.privateBucket())
+
+		return err
+	})
+
 	return
 }
 
@@ -66,7 +90,9 @@ func (bs *BowerService) Serve(state *goapp.GoroutineState) error {
 	sync := func() {
 		bs.Logger.Info("Starting a new sync...")
 
-		bs.SyncPackages()
+		if err := bs.SyncPackages(); err == nil && bs.Config.Prefetch {
+			go bs.Prefetch() // the clones can take a while, do not delay the next sync
+		}
 
 		syncEnd <- true
 	}
@@ -100,6 +126,9 @@ func (bs *BowerService) Serve(state *goapp.GoroutineState) error {
 	}
 }
 
+// SyncPackages saves the new and updated packages of the registry in batches
+// and removes the packages not listed anymore, the private packages are not
+// touched.
 func (bs *BowerService) SyncPackages() error {
 	logger := bs.Logger.WithFields(log.Fields{
 		"action": "SyncPackages",
@@ -132,60 +161,139 @@ func (bs *BowerService) SyncPackages() error {
 
 	logger.Info("End loading packages information!")
 
+	if len(pkgs) == 0 {
+		logger.Error("Empty bower packages list")
+
+		return pkgmirror.EmptyDataError // avoid removing all the packages
+	}
+
+	names := map[string]bool{}
 	for _, pkg := range pkgs {
-		bs.DB.Update(func(tx *bolt.Tx) error {
-			b := tx.Bucket(bs.Config.Code)
-
-			logger := bs.Logger.WithFields(log.Fields{
-				"package": pkg.Name,
-			})
-
-			saved := &Package{}
-			data := b.Get([]byte(pkg.Name))
-
-			if len(data) > 0 {
-				if err := json.Unmarshal(data, saved); err != nil {
-					logger.WithError(err).Info("Error while unmarshaling current package")
-				} else {
-					if saved.SourceUrl == pkg.Url {
-						logger.Debug("Skip package!")
-
-						return nil // same package no change, avoid io
-					}
-				}
-			}
+		names[pkg.Name] = true
+	}
 
-			bs.StateChan <- pkgmirror.State{
-				Message: fmt.Sprintf("Save package information: %s", pkg.Name),
-				Status:  pkgmirror.STATUS_RUNNING,
-			}
+	added, updated := 0, 0
 
-			pkg.SourceUrl = pkg.Url
-			pkg.Url = git.GitRewriteRepository(bs.Config.PublicServer, pkg.Url)
+	for start := 0; start < len(pkgs); start += syncBatchSize {
+		end := start + syncBatchSize
+		if end > len(pkgs) {
+			end = len(pkgs)
+		}
 
-			data, _ = json.Marshal(pkg)
+		bs.StateChan <- pkgmirror.State{
+			Message: fmt.Sprintf("Save packages information: %d/%d", end, len(pkgs)),
+			Status:  pkgmirror.STATUS_RUNNING,
+		}
 
-			// store the path
-			if err := b.Put([]byte(pkg.Name), data); err != nil {
-				logger.WithError(err).Error("Error updating/creating definition")
+		err := bs.DB.Update(func(tx *bolt.Tx) error {
+			a, u, err := bs.savePackages(tx.Bucket(bs.Config.Code), pkgs[start:end])
 
-				return err
-			}
+			added, updated = added+a, updated+u
 
-			logger.Info("Package saved!")
+			return err
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	removed := 0
+
+	err := bs.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bs.Config.Code)
+
+		stale := [][]byte{}
+		b.ForEach(func(k, v []byte) error {
+			if !names[string(k)] {
+				stale = append(stale, k)
+			}
 
 			return nil
 		})
+
+		for _, k := range stale {
+			bs.Logger.WithField("package", string(k)).Info("Package removed upstream, delete package")
+
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		removed = len(stale)
+
+		return nil
+	})
+
+	if err != nil {
+		logger.WithError(err).Error("Error while removing the packages")
+
+		return err
 	}
 
+	logger.WithFields(log.Fields{
+		"added":   added,
+		"updated": updated,
+		"removed": removed,
+	}).Info("End package synchronisation")
+
 	bs.StateChan <- pkgmirror.State{
-		Message: "End package synchronisation",
+		Message: fmt.Sprintf("End package synchronisation: %d added, %d updated, %d removed", added, updated, removed),
 		Status:  pkgmirror.STATUS_HOLD,
 	}
 
 	return nil
 }
 
+// savePackages stores the new or updated packages in the bucket, it returns
+// the number of added and updated packages.
+func (bs *BowerService) savePackages(b *bolt.Bucket, pkgs Packages) (int, int, error) {
+	added, updated := 0, 0
+
+	for _, pkg := range pkgs {
+		logger := bs.Logger.WithFields(log.Fields{
+			"package": pkg.Name,
+		})
+
+		saved := &Package{}
+		data := b.Get([]byte(pkg.Name))
+		exists := len(data) > 0
+
+		if exists {
+			if err := json.Unmarshal(data, saved); err != nil {
+				logger.WithError(err).Info("Error while unmarshaling current package")
+			} else if saved.SourceUrl == pkg.Url {
+				logger.Debug("Skip package!")
+
+				continue // same package no change, avoid io
+			}
+		}
+
+		pkg.SourceUrl = pkg.Url
+		pkg.Url = bs.Rewriter.Repository(bs.Config.PublicServer, pkg.Url)
+
+		data, _ = json.Marshal(pkg)
+
+		// store the path
+		if err := b.Put([]byte(pkg.Name), data); err != nil {
+			logger.WithError(err).Error("Error updating/creating definition")
+
+			return added, updated, err
+		}
+
+		if exists {
+			updated++
+		} else {
+			added++
+		}
+
+		logger.Info("Package saved!")
+	}
+
+	return added, updated, nil
+}
+
+// Get returns the package, a private package wins over a synced one.
 func (bs *BowerService) Get(name string) ([]byte, error) {
 	var data []byte
 
@@ -195,9 +303,11 @@ func (bs *BowerService) Get(name string) ([]byte, error) {
 	}).Info("Get package data")
 
 	err := bs.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bs.Config.Code)
+		raw := tx.Bucket(bs.privateBucket()).Get([]byte(name))
 
-		raw := b.Get([]byte(name))
+		if len(raw) == 0 {
+			raw = tx.Bucket(bs.Config.Code).Get([]byte(name))
+		}
 
 		if len(raw) == 0 {
 			return pkgmirror.EmptyKeyError
@@ -213,32 +323,148 @@ func (bs *BowerService) Get(name string) ([]byte, error) {
 	return data, err
 }
 
-func (bs *BowerService) WriteList(w io.Writer) error {
-	err := bs.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bs.Config.Code)
+// WriteList streams the synced and the private packages sorted by name, both
+// buckets are sorted so they are merged while iterating. The small writes are
+// buffered.
+func (bs *BowerService) WriteList(writer io.Writer) error {
+	w := bufio.NewWriter(writer)
 
-		c := b.Cursor()
+	err := bs.DB.View(func(tx *bolt.Tx) error {
+		synced := tx.Bucket(bs.Config.Code).Cursor()
+		private := tx.Bucket(bs.privateBucket()).Cursor()
 
-		k, v := c.First()
+		sk, sv := synced.First()
+		pk, pv := private.First()
 
 		w.Write([]byte{'['})
 
-		if k == nil {
-			w.Write([]byte{']'})
+		for i := 0; sk != nil || pk != nil; i++ {
+			if i > 0 {
+				w.Write([]byte{','})
+			}
 
-			return nil
+			switch c := bytes.Compare(pk, sk); {
+			case sk == nil || (pk != nil && c < 0):
+				w.Write(pv)
+				pk, pv = private.Next()
+			case c == 0: // the private package wins
+				w.Write(pv)
+				pk, pv = private.Next()
+				sk, sv = synced.Next()
+			default:
+				w.Write(sv)
+				sk, sv = synced.Next()
+			}
 		}
 
-		w.Write(v)
-
-		for k, v := c.Next(); k != nil; k, v = c.Next() {
-			w.Write([]byte{','})
-			w.Write(v)
-		}
 		w.Write([]byte{']'})
 
 		return nil
 	})
 
-	return err
+	if err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// Search returns the packages matching the query, like the registry the name
+// must contain the query, case insensitive. Names containing the letters of the
+// query in order are returned after. Results are sorted by relevance and name.
+func (bs *BowerService) Search(query string) (Packages, error) {
+	results := searchResults{}
+
+	err := bs.DB.View(func(tx *bolt.Tx) error {
+		private := tx.Bucket(bs.privateBucket())
+
+		add := func(k, v []byte) error {
+			score := searchScore(string(k), query)
+
+			if score < 0 {
+				return nil
+			}
+
+			pkg := &Package{}
+			if err := json.Unmarshal(v, pkg); err != nil {
+				return err
+			}
+
+			results = append(results, &searchResult{Package: pkg, Score: score})
+
+			return nil
+		}
+
+		if err := private.ForEach(add); err != nil {
+			return err
+		}
+
+		return tx.Bucket(bs.Config.Code).ForEach(func(k, v []byte) error {
+			if private.Get(k) != nil {
+				return nil // the private package wins
+			}
+
+			return add(k, v)
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(results)
+
+	pkgs := make(Packages, 0)
+	for _, result := range results {
+		pkgs = append(pkgs, result.Package)
+	}
+
+	return pkgs, nil
+}
+
+// searchScore returns the relevance of the name for the query, lower is better:
+// 0 for an exact match, 1 for a prefix, 2 for a substring and 3 if the name
+// contains the letters of the query in order. It returns -1 if the name does
+// not match.
+func searchScore(name, query string) int {
+	name, query = strings.ToLower(name), strings.ToLower(query)
+
+	switch {
+	case name == query:
+		return 0
+	case strings.HasPrefix(name, query):
+		return 1
+	case strings.Contains(name, query):
+		return 2
+	}
+
+	i := 0
+	for _, r := range name {
+		if i < len(query) && strings.HasPrefix(query[i:], string(r)) {
+			i += len(string(r))
+		}
+	}
+
+	if i == len(query) {
+		return 3
+	}
+
+	return -1
+}
+
+type searchResult struct {
+	Package *Package
+	Score   int
+}
+
+type searchResults []*searchResult
+
+func (r searchResults) Len() int      { return len(r) }
+func (r searchResults) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r searchResults) Less(i, j int) bool {
+	if r[i].Score != r[j].Score {
+		return r[i].Score < r[j].Score
+	}
+
+	return r[i].Package.Name < r[j].Package.Name
 }
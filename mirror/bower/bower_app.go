@@ -8,12 +8,16 @@ package bower
 import (
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/rande/goapp"
 	"github.com/rande/pkgmirror"
+	"github.com/rande/pkgmirror/mirror/git"
 	"goji.io"
 	"goji.io/pat"
+	"goji.io/pattern"
 	"golang.org/x/net/context"
 )
 
@@ -34,6 +38,18 @@ func ConfigureApp(config *pkgmirror.Config, l *goapp.Lifecycle) {
 					s.Config.Path = fmt.Sprintf("%s/bower", config.DataDir)
 					s.Config.PublicServer = config.PublicServer
 					s.Config.SourceServer = conf.Server
+					s.Config.Token = conf.Token
+					s.Config.Prefetch = conf.Prefetch
+					s.Config.PrefetchPackages = conf.PrefetchPackages
+					s.Mirrors = func(server string) *git.GitService {
+						for code, c := range config.Git {
+							if c.Enabled && c.Server == server {
+								return app.Get(fmt.Sprintf("pkgmirror.git.%s", code)).(*git.GitService)
+							}
+						}
+
+						return nil
+					}
 					s.Logger = logger.WithFields(log.Fields{
 						"handler": "bower",
 						"server":  s.Config.SourceServer,
@@ -41,6 +57,12 @@ func ConfigureApp(config *pkgmirror.Config, l *goapp.Lifecycle) {
 					})
 					s.StateChan = pkgmirror.GetStateChannel(fmt.Sprintf("pkgmirror.bower.%s", name), app.Get("pkgmirror.channel.state").(chan pkgmirror.State))
 
+					if rewriter, err := git.NewRewriterFromConfig(config); err != nil {
+						panic(err)
+					} else {
+						s.Rewriter = rewriter
+					}
+
 					if err := s.Init(app); err != nil {
 						panic(err)
 					}
@@ -92,12 +114,84 @@ func ConfigureHttp(name string, conf *pkgmirror.BowerConfig, app *goapp.App) {
 		}
 	})
 
-	mux.HandleFuncC(pat.Get(fmt.Sprintf("/bower/%s/packages/:name", name)), func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		if data, err := bowerService.Get(fmt.Sprintf("%s", pat.Param(ctx, "name"))); err != nil {
+	// names may contain slashes or escaped characters, so wildcards are used
+	mux.HandleFuncC(pat.Get(fmt.Sprintf("/bower/%s/packages/search/*", name)), func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		query, err := url.PathUnescape(strings.TrimPrefix(pattern.Path(ctx), "/"))
+		if err != nil {
+			pkgmirror.SendWithHttpCode(w, 400, err.Error())
+
+			return
+		}
+
+		pkgs, err := bowerService.Search(query)
+		if err != nil {
+			pkgmirror.SendWithHttpCode(w, 500, err.Error())
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		pkgmirror.Serialize(w, pkgs)
+	})
+
+	mux.HandleFuncC(pat.Get(fmt.Sprintf("/bower/%s/packages/*", name)), func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		pkgName, err := url.PathUnescape(strings.TrimPrefix(pattern.Path(ctx), "/"))
+		if err != nil {
+			pkgmirror.SendWithHttpCode(w, 400, err.Error())
+
+			return
+		}
+
+		if data, err := bowerService.Get(pkgName); err != nil {
 			pkgmirror.SendWithHttpCode(w, 404, err.Error())
 		} else {
 			w.Header().Set("Content-Type", "application/json")
 			w.Write(data)
 		}
 	})
+
+	// bower register posts the name and the url as a form
+	mux.HandleFuncC(pat.Post(fmt.Sprintf("/bower/%s/packages", name)), func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		if !bowerService.ValidToken(pkgmirror.RequestToken(r)) {
+			pkgmirror.SendWithHttpCode(w, 403, pkgmirror.InvalidTokenError.Error())
+
+			return
+		}
+
+		switch err := bowerService.Register(r.FormValue("name"), r.FormValue("url")); err {
+		case nil:
+			pkgmirror.SendWithHttpCode(w, 201, "Package registered")
+		case pkgmirror.InvalidPackageError:
+			pkgmirror.SendWithHttpCode(w, 400, err.Error())
+		case pkgmirror.PackageExistsError:
+			pkgmirror.SendWithHttpCode(w, 403, err.Error())
+		default:
+			pkgmirror.SendWithHttpCode(w, 500, err.Error())
+		}
+	})
+
+	mux.HandleFuncC(pat.Delete(fmt.Sprintf("/bower/%s/packages/*", name)), func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		if !bowerService.ValidToken(pkgmirror.RequestToken(r)) {
+			pkgmirror.SendWithHttpCode(w, 403, pkgmirror.InvalidTokenError.Error())
+
+			return
+		}
+
+		pkgName, err := url.PathUnescape(strings.TrimPrefix(pattern.Path(ctx), "/"))
+		if err != nil {
+			pkgmirror.SendWithHttpCode(w, 400, err.Error())
+
+			return
+		}
+
+		switch err := bowerService.Unregister(pkgName); err {
+		case nil:
+			pkgmirror.SendWithHttpCode(w, 200, "Package removed")
+		case pkgmirror.ResourceNotFoundError:
+			pkgmirror.SendWithHttpCode(w, 404, err.Error())
+		default:
+			pkgmirror.SendWithHttpCode(w, 500, err.Error())
+		}
+	})
 }
@@ -0,0 +1,156 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package bower
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/boltdb/bolt"
+	"github.com/rande/pkgmirror"
+)
+
+// prefetchWorkers is the number of repositories waiting for a clone at the
+// same time, the clones are bounded by the workers of the git mirror.
+const prefetchWorkers = 4
+
+// CanPrefetch checks the name against the prefetch glob patterns, an empty
+// list allows any package.
+func (bs *BowerService) CanPrefetch(name string) bool {
+	if len(bs.Config.PrefetchPackages) == 0 {
+		return true
+	}
+
+	for _, pattern := range bs.Config.PrefetchPackages {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Prefetch clones the missing repositories of the packages into the matching
+// git mirrors, so the first install does not wait for the clone. Packages
+// without a git mirror are skipped. It returns the number of available
+// repositories, a running prefetch is not started twice.
+func (bs *BowerService) Prefetch() (int, error) {
+	if !atomic.CompareAndSwapInt32(&bs.prefetching, 0, 1) {
+		return 0, pkgmirror.SyncInProgressError
+	}
+
+	defer atomic.StoreInt32(&bs.prefetching, 0)
+
+	logger := bs.Logger.WithFields(log.Fields{
+		"action": "Prefetch",
+	})
+
+	pkgs := Packages{}
+
+	err := bs.DB.View(func(tx *bolt.Tx) error {
+		private := tx.Bucket(bs.privateBucket())
+
+		for _, bucket := range [][]byte{bs.privateBucket(), bs.Config.Code} {
+			synced := string(bucket) == string(bs.Config.Code)
+
+			err := tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+				if !bs.CanPrefetch(string(k)) {
+					return nil
+				}
+
+				// like Search, a private package shadows the synced one
+				if synced && private.Get(k) != nil {
+					return nil
+				}
+
+				pkg := &Package{}
+				if err := json.Unmarshal(v, pkg); err != nil {
+					return err
+				}
+
+				pkgs = append(pkgs, pkg)
+
+				return nil
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		logger.WithError(err).Error("Unable to load the packages")
+
+		return 0, err
+	}
+
+	logger.WithField("packages", len(pkgs)).Info("Starting prefetch")
+
+	queue := make(chan *Package)
+	wg := sync.WaitGroup{}
+	count := int32(0)
+
+	for i := 0; i < prefetchWorkers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for pkg := range queue {
+				if bs.prefetchPackage(pkg) {
+					atomic.AddInt32(&count, 1)
+				}
+			}
+		}()
+	}
+
+	for _, pkg := range pkgs {
+		queue <- pkg
+	}
+
+	close(queue)
+	wg.Wait()
+
+	logger.WithField("repositories", count).Info("End prefetch")
+
+	return int(count), nil
+}
+
+// prefetchPackage clones the repository of the package if needed, it returns
+// true if the repository is available on the mirror.
+func (bs *BowerService) prefetchPackage(pkg *Package) bool {
+	if bs.Mirrors == nil {
+		return false
+	}
+
+	server, path, ok := bs.Rewriter.Locate(pkg.SourceUrl)
+	if !ok {
+		return false
+	}
+
+	gs := bs.Mirrors(server)
+	if gs == nil || len(gs.Config.Clone) == 0 {
+		return false // no mirror able to clone the repository
+	}
+
+	if err := gs.EnsureRepository(path); err != nil {
+		bs.Logger.WithFields(log.Fields{
+			"action":  "Prefetch",
+			"package": pkg.Name,
+			"path":    path,
+		}).WithError(err).Info("Unable to prefetch the repository")
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,84 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package bower
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/boltdb/bolt"
+	"github.com/rande/pkgmirror"
+	"github.com/rande/pkgmirror/mirror/git"
+)
+
+// privateBucket stores the packages registered on the mirror, the sync never
+// writes in this bucket.
+func (bs *BowerService) privateBucket() []byte {
+	return []byte(fmt.Sprintf("%s-private", bs.Config.Code))
+}
+
+// Register stores a private package, like the registry a name cannot be
+// registered twice and the url must be a git repository.
+func (bs *BowerService) Register(name, url string) error {
+	logger := bs.Logger.WithFields(log.Fields{
+		"action":  "Register",
+		"package": name,
+		"url":     url,
+	})
+
+	if len(name) == 0 || strings.HasPrefix(name, "/") || strings.Contains(name, "..") || !git.GIT_REPOSITORY.MatchString(url) {
+		return pkgmirror.InvalidPackageError
+	}
+
+	return bs.DB.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bs.Config.Code).Get([]byte(name)) != nil || tx.Bucket(bs.privateBucket()).Get([]byte(name)) != nil {
+			return pkgmirror.PackageExistsError
+		}
+
+		data, _ := json.Marshal(&Package{
+			Name:      name,
+			Url:       bs.Rewriter.Repository(bs.Config.PublicServer, url),
+			SourceUrl: url,
+		})
+
+		if err := tx.Bucket(bs.privateBucket()).Put([]byte(name), data); err != nil {
+			logger.WithError(err).Error("Error while registering the package")
+
+			return err
+		}
+
+		logger.Info("Package registered!")
+
+		return nil
+	})
+}
+
+// Unregister removes a private package, the synced packages cannot be removed.
+func (bs *BowerService) Unregister(name string) error {
+	return bs.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bs.privateBucket())
+
+		if b.Get([]byte(name)) == nil {
+			return pkgmirror.ResourceNotFoundError
+		}
+
+		bs.Logger.WithFields(log.Fields{
+			"action":  "Unregister",
+			"package": name,
+		}).Info("Package unregistered!")
+
+		return b.Delete([]byte(name))
+	})
+}
+
+// ValidToken returns true if the token matches the configured one, any token
+// is valid if none is configured.
+func (bs *BowerService) ValidToken(token string) bool {
+	return len(bs.Config.Token) == 0 || subtle.ConstantTimeCompare([]byte(token), []byte(bs.Config.Token)) == 1
+}
@@ -0,0 +1,295 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package bower
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/boltdb/bolt"
+	"github.com/rande/pkgmirror"
+	"github.com/rande/pkgmirror/mirror/git"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBowerService(t *testing.T, pkgs ...*Package) (*BowerService, func()) {
+	dir, _ := ioutil.TempDir("", "pkgmirror-bower")
+
+	bs := NewBowerService()
+	bs.Config.Path = dir
+	bs.Logger = log.NewEntry(log.New())
+
+	assert.NoError(t, bs.Init(nil))
+
+	bs.DB.Update(func(tx *bolt.Tx) error {
+		for _, pkg := range pkgs {
+			data, _ := json.Marshal(pkg)
+
+			tx.Bucket(bs.Config.Code).Put([]byte(pkg.Name), data)
+		}
+
+		return nil
+	})
+
+	return bs, func() {
+		bs.DB.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_Search_Score(t *testing.T) {
+	assert.Equal(t, 0, searchScore("jquery", "jQuery"))
+	assert.Equal(t, 1, searchScore("jquery-ui", "jquery"))
+	assert.Equal(t, 2, searchScore("angular-jquery", "jquery"))
+	assert.Equal(t, 3, searchScore("jquery-ui", "jqui"))
+	assert.Equal(t, -1, searchScore("angular", "jquery"))
+}
+
+func Test_Search(t *testing.T) {
+	bs, clean := newTestBowerService(t,
+		&Package{Name: "angular-jquery", Url: "https://github.com/foo/angular-jquery.git"},
+		&Package{Name: "jquery", Url: "https://github.com/jquery/jquery.git"},
+		&Package{Name: "jquery-ui", Url: "https://github.com/jquery/jquery-ui.git"},
+		&Package{Name: "angular", Url: "https://github.com/angular/angular.git"},
+		&Package{Name: "org/j-query", Url: "https://github.com/org/j-query.git"},
+	)
+	defer clean()
+
+	pkgs, err := bs.Search("jquery")
+	assert.NoError(t, err)
+
+	names := []string{}
+	for _, pkg := range pkgs {
+		names = append(names, pkg.Name)
+	}
+
+	assert.Equal(t, []string{"jquery", "jquery-ui", "angular-jquery", "org/j-query"}, names)
+
+	data, err := bs.Get("org/j-query")
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "https://github.com/org/j-query.git")
+}
+
+func Test_Register(t *testing.T) {
+	bs, clean := newTestBowerService(t,
+		&Package{Name: "jquery", Url: "https://github.com/jquery/jquery.git"},
+		&Package{Name: "lodash", Url: "https://github.com/lodash/lodash.git"},
+	)
+	defer clean()
+
+	assert.Equal(t, pkgmirror.InvalidPackageError, bs.Register("", "https://github.com/foo/bar.git"))
+	assert.Equal(t, pkgmirror.InvalidPackageError, bs.Register("bar", "not an url"))
+	assert.Equal(t, pkgmirror.PackageExistsError, bs.Register("jquery", "https://git.example.com/jquery.git"))
+
+	assert.NoError(t, bs.Register("private-ui", "https://git.example.com/private/ui.git"))
+	assert.NoError(t, bs.Register("acme/widgets", "git@git.example.com:acme/widgets.git"))
+	assert.Equal(t, pkgmirror.PackageExistsError, bs.Register("private-ui", "https://git.example.com/private/ui.git"))
+
+	data, err := bs.Get("private-ui")
+	assert.NoError(t, err)
+
+	pkg := &Package{}
+	json.Unmarshal(data, pkg)
+	assert.Equal(t, "https://git.example.com/private/ui.git", pkg.SourceUrl)
+
+	// the sync does not touch the private packages
+	bs.DB.Update(func(tx *bolt.Tx) error {
+		data, _ := json.Marshal(&Package{Name: "private-ui", Url: "https://github.com/other/ui.git"})
+
+		return tx.Bucket(bs.Config.Code).Put([]byte("private-ui"), data)
+	})
+
+	data, err = bs.Get("private-ui")
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "https://git.example.com/private/ui.git")
+
+	buf := bytes.NewBuffer([]byte(""))
+	assert.NoError(t, bs.WriteList(buf))
+
+	pkgs := Packages{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &pkgs))
+
+	names := []string{}
+	for _, pkg := range pkgs {
+		names = append(names, pkg.Name)
+	}
+
+	assert.Equal(t, []string{"acme/widgets", "jquery", "lodash", "private-ui"}, names)
+	assert.Equal(t, "https://git.example.com/private/ui.git", pkgs[3].SourceUrl)
+
+	pkgs, err = bs.Search("ui")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pkgs))
+	assert.Equal(t, "https://git.example.com/private/ui.git", pkgs[0].SourceUrl)
+
+	assert.Equal(t, pkgmirror.ResourceNotFoundError, bs.Unregister("jquery"))
+	assert.NoError(t, bs.Unregister("acme/widgets"))
+
+	_, err = bs.Get("acme/widgets")
+	assert.Equal(t, pkgmirror.EmptyKeyError, err)
+}
+
+func Test_ValidToken(t *testing.T) {
+	bs := NewBowerService()
+
+	assert.True(t, bs.ValidToken(""))
+
+	bs.Config.Token = "secret"
+
+	assert.True(t, bs.ValidToken("secret"))
+	assert.False(t, bs.ValidToken(""))
+	assert.False(t, bs.ValidToken("other"))
+}
+
+func Test_SyncPackages(t *testing.T) {
+	registry := Packages{
+		{Name: "jquery", Url: "https://github.com/jquery/jquery.git"},
+		{Name: "lodash", Url: "https://github.com/lodash/lodash.git"},
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(registry)
+	}))
+	defer ts.Close()
+
+	bs, clean := newTestBowerService(t)
+	defer clean()
+
+	bs.Config.SourceServer = ts.URL
+	bs.StateChan = make(chan pkgmirror.State)
+
+	states := make(chan []string, 1)
+	go func() {
+		messages := []string{}
+		for state := range bs.StateChan {
+			if strings.HasPrefix(state.Message, "End") {
+				messages = append(messages, state.Message)
+			}
+		}
+
+		states <- messages
+	}()
+
+	assert.NoError(t, bs.Register("private-ui", "https://git.example.com/private/ui.git"))
+	assert.NoError(t, bs.SyncPackages())
+
+	registry = Packages{
+		{Name: "jquery", Url: "https://github.com/jquery/jquery.git"},
+		{Name: "lodash", Url: "https://github.com/lodash/lodash-next.git"},
+		{Name: "angular", Url: "https://github.com/angular/angular.git"},
+	}
+
+	assert.NoError(t, bs.SyncPackages())
+
+	registry = Packages{
+		{Name: "angular", Url: "https://github.com/angular/angular.git"},
+	}
+
+	assert.NoError(t, bs.SyncPackages())
+
+	registry = Packages{}
+
+	assert.Equal(t, pkgmirror.EmptyDataError, bs.SyncPackages())
+
+	close(bs.StateChan)
+	assert.Equal(t, []string{
+		"End package synchronisation: 2 added, 0 updated, 0 removed",
+		"End package synchronisation: 1 added, 1 updated, 0 removed",
+		"End package synchronisation: 0 added, 0 updated, 2 removed",
+	}, <-states)
+
+	buf := bytes.NewBuffer([]byte(""))
+	assert.NoError(t, bs.WriteList(buf))
+
+	pkgs := Packages{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &pkgs))
+
+	assert.Equal(t, 2, len(pkgs))
+	assert.Equal(t, "angular", pkgs[0].Name)
+	assert.Equal(t, "private-ui", pkgs[1].Name)
+}
+
+func Test_CanPrefetch(t *testing.T) {
+	bs := NewBowerService()
+
+	assert.True(t, bs.CanPrefetch("jquery"))
+
+	bs.Config.PrefetchPackages = []string{"jquery*", "acme/*"}
+
+	assert.True(t, bs.CanPrefetch("jquery-ui"))
+	assert.True(t, bs.CanPrefetch("acme/widgets"))
+	assert.False(t, bs.CanPrefetch("lodash"))
+}
+
+func Test_Prefetch(t *testing.T) {
+	bs, clean := newTestBowerService(t,
+		&Package{Name: "foo", SourceUrl: "https://git.example.com/org/foo.git"},
+		&Package{Name: "bar", SourceUrl: "https://git.example.com/org/bar.git"},
+		&Package{Name: "other", SourceUrl: "https://other.example.com/org/other.git"},
+	)
+	defer clean()
+
+	dir, _ := ioutil.TempDir("", "pkgmirror-bower-git")
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, exec.Command("git", "clone", "--mirror", "../../fixtures/git/foo.bare", dir+"/upstream/org/foo.git").Run())
+
+	gs := git.NewGitService()
+	gs.Logger = bs.Logger
+	gs.Config.DataDir = dir + "/data"
+	gs.Config.Server = "git.example.com"
+	gs.Config.Clone = dir + "/upstream/{path}"
+	gs.StateChan = make(chan pkgmirror.State, 10)
+
+	assert.NoError(t, gs.Init(nil))
+	defer gs.DB.Close()
+
+	bs.Mirrors = func(server string) *git.GitService {
+		if server == "git.example.com" {
+			return gs
+		}
+
+		return nil
+	}
+
+	bs.Config.PrefetchPackages = []string{"foo", "other"}
+
+	count, err := bs.Prefetch()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.True(t, gs.Has("org/foo.git"))
+	assert.False(t, gs.Has("org/bar.git"))
+
+	bs.Config.PrefetchPackages = nil
+
+	count, err = bs.Prefetch()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count) // bar does not exist upstream
+
+	// the private package shadows the package added later by the sync
+	assert.NoError(t, exec.Command("git", "clone", "--mirror", "../../fixtures/git/foo.bare", dir+"/upstream/org/bar.git").Run())
+	assert.NoError(t, bs.Register("baz", "https://git.example.com/org/foo.git"))
+	assert.NoError(t, bs.DB.Update(func(tx *bolt.Tx) error {
+		data, _ := json.Marshal(&Package{Name: "baz", SourceUrl: "https://git.example.com/org/bar.git"})
+
+		return tx.Bucket(bs.Config.Code).Put([]byte("baz"), data)
+	}))
+
+	bs.Config.PrefetchPackages = []string{"baz"}
+
+	count, err = bs.Prefetch()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.False(t, gs.Has("org/bar.git"))
+}
@@ -34,6 +34,7 @@ func NewComposerService() *ComposerService {
 			Code:         []byte("packagist"),
 			Path:         "./data/composer",
 		},
+		Rewriter: git.NewRewriter(),
 	}
 }
 
@@ -43,6 +44,7 @@ type ComposerService struct {
 	Logger    *log.Entry
 	lock      bool
 	StateChan chan pkgmirror.State
+	Rewriter  *git.Rewriter
 }
 
 func (ps *ComposerService) Init(app *goapp.App) (err error) {
@@ -486,8 +488,23 @@ func (ps *ComposerService) savePackage(pkg *PackageInformation) error {
 
 		for name := range pkg.PackageResult.Packages {
 			for _, version := range pkg.PackageResult.Packages[name] {
-				version.Dist.URL = git.GitRewriteArchive(ps.Config.PublicServer, version.Dist.URL)
-				version.Source.URL = git.GitRewriteRepository(ps.Config.PublicServer, version.Source.URL)
+				if version.Source.Type == "svn" {
+					if repository, ref, archive, ok := ps.Rewriter.Svn(ps.Config.PublicServer, version.Source.URL, version.Source.Reference); ok {
+						// served by a svn mirror as a git repository
+						version.Source.Type = "git"
+						version.Source.URL = repository
+						version.Source.Reference = ref
+						version.Dist.Type = "zip"
+						version.Dist.URL = archive
+						version.Dist.Reference = ref
+						version.Dist.Shasum = ""
+
+						continue
+					}
+				}
+
+				version.Dist.URL = ps.Rewriter.Archive(ps.Config.PublicServer, version.Dist.URL)
+				version.Source.URL = ps.Rewriter.Repository(ps.Config.PublicServer, version.Source.URL)
 			}
 		}
 
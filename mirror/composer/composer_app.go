@@ -12,6 +12,7 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/rande/goapp"
 	"github.com/rande/pkgmirror"
+	"github.com/rande/pkgmirror/mirror/git"
 	"goji.io"
 	"goji.io/pat"
 	"golang.org/x/net/context"
@@ -43,6 +44,12 @@ func ConfigureApp(config *pkgmirror.Config, l *goapp.Lifecycle) {
 					})
 					s.StateChan = pkgmirror.GetStateChannel(fmt.Sprintf("pkgmirror.composer.%s", name), app.Get("pkgmirror.channel.state").(chan pkgmirror.State))
 
+					if rewriter, err := git.NewRewriterFromConfig(config); err != nil {
+						panic(err)
+					} else {
+						s.Rewriter = rewriter
+					}
+
 					if err := s.Init(app); err != nil {
 						panic(err)
 					}
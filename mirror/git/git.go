@@ -6,10 +6,12 @@
 package git
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"sync"
@@ -32,17 +34,49 @@ var (
 	GIT_REPOSITORY = regexp.MustCompile(`^(((git|http(s|)):\/\/|git@))([\w-\.]+@|)([\w-\.]+)(\/|:)([\w-\.\/]+?)(\.git|)$`)
 	SVN_REPOSITORY = regexp.MustCompile(`(svn:\/\/(.*)|(.*)\.svn\.(.*))`)
 
-	CACHEABLE_REF = regexp.MustCompile(`([\w\d]{40}|[\w\d]+\.[\w\d]+\.[\w\d]+(-[\w\d]+|))`)
+	COMMIT_ID = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+	// supported archive formats with their content type
+	ARCHIVE_FORMATS = map[string]string{
+		"zip":    "application/zip",
+		"tar":    "application/x-tar",
+		"tar.gz": "application/x-gzip",
+	}
 )
 
 func NewGitService() *GitService {
+	config := &GitConfig{
+		DataDir:          "./data/git",
+		Binary:           "git",
+		SourceServer:     "git@github.com:%s",
+		PublicServer:     "http://localhost:8000",
+		Code:             []byte("git"),
+		FetchWorkers:     5,
+		FetchTimeout:     5 * time.Minute,
+		FetchBackoff:     1 * time.Hour,
+		FetchMinInterval: 1 * time.Minute,
+		FetchMaxInterval: 1 * time.Hour,
+		CloneWorkers:     2,
+		CloneTimeout:     10 * time.Minute,
+		GcInterval:       24 * time.Hour,
+		UnusedAction:     "skip",
+		Type:             "git",
+		SvnLayout:        "std",
+		ArchiveWorkers:   4,
+		ArchiveQueueSize: 100,
+	}
+
 	return &GitService{
-		Config: &GitConfig{
-			DataDir:      "./data/git",
-			Binary:       "git",
-			SourceServer: "git@github.com:%s",
-			PublicServer: "http://localhost:8000",
-		},
+		Config:     config,
+		Backend:    &BinaryBackend{Config: config},
+		fetchQueue: make(chan string, 100),
+		queued:     map[string]bool{},
+		fetching:   map[string]bool{},
+		cloneQueue: make(chan *cloneJob),
+		clones:     map[string]*cloneJob{},
+		clients:    map[string][]time.Time{},
+		accessed:   map[string]time.Time{},
+		archives:   &archiveQueue{jobs: map[string]*archiveJob{}},
 		Vault: &vault.Vault{
 			Algo: "no_op",
 			Driver: &vault.DriverFs{
@@ -53,47 +87,152 @@ func NewGitService() *GitService {
 }
 
 type GitConfig struct {
-	PublicServer string
-	SourceServer string
-	Server       string
-	DataDir      string
-	Binary       string
-	Clone        string
+	PublicServer      string
+	SourceServer      string
+	Server            string
+	DataDir           string
+	Binary            string
+	Clone             string
+	Code              []byte
+	FetchWorkers      int
+	FetchTimeout      time.Duration
+	FetchBackoff      time.Duration
+	WebhookSecret     string
+	CloneWorkers      int
+	CloneTimeout      time.Duration
+	ArchivePrefix     bool
+	Lfs               bool
+	Submodules        bool
+	ArchiveSubmodules bool
+	Credentials       *Credentials
+	CloneAllow        []string // glob patterns, ie: symfony/*.git
+	CloneDeny         []string
+	CloneMaxSize      int64 // in bytes
+	CloneRateLimit    int   // clones per client and per hour
+	Prune             bool  // remove the refs deleted upstream on fetch
+	ImmutableTags     bool  // keep the tags already mirrored when upstream moves or deletes them
+	GcInterval        time.Duration
+	UnusedAfter       time.Duration // 0 to keep the unused repositories
+	UnusedAction      string        // skip, archive or remove
+	FetchMinInterval  time.Duration
+	FetchMaxInterval  time.Duration
+	Type              string // git or svn
+	SvnLayout         string // std or none
+	ArchiveWorkers    int
+	ArchiveQueueSize  int      // archives waiting for a worker, 0 or less for no limit
+	Refs              []string // ref patterns to mirror, all refs if empty
+	Filter            string   // partial clone filter
+	Partial           []*PartialRule
 }
 
 type GitService struct {
-	DB        *bolt.DB
-	Config    *GitConfig
-	Logger    *log.Entry
-	Vault     *vault.Vault
-	StateChan chan pkgmirror.State
+	DB         *bolt.DB
+	Config     *GitConfig
+	Backend    Backend
+	Logger     *log.Entry
+	Vault      *vault.Vault
+	StateChan  chan pkgmirror.State
+	Mirrors    func(server string) *GitService // returns the enabled mirror of a server, or nil
+	fetchQueue chan string
+	queued     map[string]bool
+	fetching   map[string]bool
+	cloneQueue chan *cloneJob
+	clones     map[string]*cloneJob
+	clients    map[string][]time.Time
+	accessed   map[string]time.Time
+	archives   *archiveQueue
+	lock       sync.Mutex
 }
 
-func (gs *GitService) Init(app *goapp.App) error {
+type fetchResult struct {
+	Path  string
+	Error error
+}
+
+type cloneJob struct {
+	Path  string
+	Done  chan struct{}
+	Error error
+}
+
+func (gs *GitService) Init(app *goapp.App) (err error) {
 	os.MkdirAll(string(filepath.Separator)+gs.Config.DataDir, 0755)
 
-	return nil
+	for i := 0; i < gs.Config.CloneWorkers; i++ {
+		go gs.cloneWorker()
+	}
+
+	gs.archives.slots = make(chan struct{}, gs.Config.ArchiveWorkers)
+
+	if gs.DB, err = pkgmirror.OpenDatabaseWithBucket(gs.Config.DataDir, gs.Config.Code); err != nil {
+		gs.Logger.WithFields(log.Fields{
+			"error":  err,
+			"path":   gs.Config.DataDir,
+			"bucket": string(gs.Config.Code),
+			"action": "Init",
+		}).Error("Unable to open the internal database")
+
+		return
+	}
+
+	err = gs.DB.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(gs.refLogBucket())
+
+		return err
+	})
+
+	return
 }
 
 func (gs *GitService) Serve(state *goapp.GoroutineState) error {
 	syncEnd := make(chan bool)
+	stop := make(chan bool)
+
+	// the sync and the priority fetches write to the database, they must be
+	// completed before closing it.
+	wg := sync.WaitGroup{}
 
-	sync := func() {
+	run := func() {
 		gs.Logger.Info("Starting a new sync...")
 
-		gs.syncRepositories(fmt.Sprintf("%s/%s", gs.Config.DataDir, gs.Config.Server))
+		gs.syncRepositories()
 
-		syncEnd <- true
+		gs.maintainRepositories()
+
+		select {
+		case syncEnd <- true:
+		case <-stop:
+		}
 	}
 
 	// start the first sync
-	go sync()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		run()
+	}()
 
 	for {
 		select {
 		case <-state.In:
+			close(stop)
+
+			gs.Logger.Info("Wait for the running fetches before closing the database...")
+
+			wg.Wait()
+
+			gs.DB.Close()
 			return nil
 
+		case path := <-gs.fetchQueue:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				gs.priorityFetch(path)
+			}()
+
 		case <-syncEnd:
 			gs.StateChan <- pkgmirror.State{
 				Message: "Wait for a new run",
@@ -102,23 +241,100 @@ func (gs *GitService) Serve(state *goapp.GoroutineState) error {
 
 			gs.Logger.Info("Wait before starting a new sync...")
 
-			// we recursively call sync unless a state.In comes in to exist the current
-			// go routine (ie, the Serve function). This might not close the sync processus
-			// completely. We need to have a proper channel (queue mode) for git fetch.
-			// This will probably make this current code obsolete.
+			// the next sync is skipped if the service stops during the wait
+			wg.Add(1)
 			go func() {
-				time.Sleep(60 * time.Second)
-				sync()
+				defer wg.Done()
+
+				select {
+				case <-time.After(gs.Config.FetchMinInterval):
+					run()
+				case <-stop:
+				}
 			}()
 		}
 	}
 }
 
-func (gs *GitService) syncRepositories(service string) {
-	gs.Logger.WithFields(log.Fields{
+func (gs *GitService) syncRepositories() {
+	service := gs.dataFolder()
+
+	logger := gs.Logger.WithFields(log.Fields{
 		"action":  "SyncRepositories",
 		"datadir": service,
-	}).Info("Sync service's repositories")
+	})
+
+	logger.Info("Sync service's repositories")
+
+	dm := pkgmirror.NewWorkerManager(gs.Config.FetchWorkers, func(id int, data <-chan interface{}, result chan interface{}) {
+		for raw := range data {
+			path := raw.(string)
+
+			gs.StateChan <- pkgmirror.State{
+				Message: fmt.Sprintf("Fetch %s", path),
+				Status:  pkgmirror.STATUS_RUNNING,
+			}
+
+			logger.WithFields(log.Fields{
+				"path":   path,
+				"worker": id,
+			}).Info("Sync repository")
+
+			result <- fetchResult{Path: path, Error: gs.Fetch(path)}
+		}
+	})
+
+	dm.ResultCallback(func(raw interface{}) {
+		r := raw.(fetchResult)
+
+		gs.recordFetch(r.Path, r.Error)
+	})
+
+	dm.Start()
+
+	now := time.Now()
+
+	for _, path := range gs.findRepositories() {
+		repo, err := gs.GetRepository(path)
+
+		if err == nil && !repo.CanFetch(now) {
+			logger.WithFields(log.Fields{
+				"path":     path,
+				"failures": repo.Failures,
+				"retry_at": repo.RetryAt,
+			}).Debug("Skipping failing repository until the backoff delay expires")
+
+			continue
+		}
+
+		if err == nil && !repo.Due(now) {
+			logger.WithFields(log.Fields{
+				"path":          path,
+				"next_fetch_at": repo.NextFetchAt,
+			}).Debug("Skipping repository until the next fetch")
+
+			continue
+		}
+
+		if err == nil && gs.Config.UnusedAction == "skip" && repo.Unused(now, gs.Config.UnusedAfter) {
+			logger.WithFields(log.Fields{
+				"path":           path,
+				"last_access_at": repo.LastAccessAt,
+			}).Debug("Skipping unused repository")
+
+			continue
+		}
+
+		dm.Add(path)
+	}
+
+	dm.Wait()
+}
+
+// findRepositories returns the bare repositories available in the data folder,
+// paths are relative to the data folder.
+func (gs *GitService) findRepositories() []string {
+	service := gs.dataFolder()
 
 	searchPaths := []string{
 		fmt.Sprintf("%s/*.git", service),
@@ -131,107 +347,404 @@ func (gs *GitService) syncRepositories(service string) {
 		if p, err := filepath.Glob(searchPath); err != nil {
 			continue
 		} else {
-			paths = append(paths, p...)
+			for _, path := range p {
+				paths = append(paths, path[len(service)+1:])
+			}
 		}
 	}
 
-	for _, path := range paths {
-		logger := gs.Logger.WithFields(log.Fields{
-			"path":   path[len(service):],
-			"action": "SyncRepositories",
-		})
+	return paths
+}
+
+// EnqueueFetch schedules an immediate fetch of the repository, outside of the
+// periodic sync. It returns false if the repository is already queued or if
+// the queue is full.
+func (gs *GitService) EnqueueFetch(path string) bool {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	if gs.queued[path] {
+		return false
+	}
+
+	select {
+	case gs.fetchQueue <- path:
+		gs.queued[path] = true
 
+		return true
+	default:
+		return false // queue is full
+	}
+}
+
+func (gs *GitService) priorityFetch(path string) {
+	gs.lock.Lock()
+	delete(gs.queued, path)
+	gs.lock.Unlock()
+
+	gs.StateChan <- pkgmirror.State{
+		Message: fmt.Sprintf("Fetch %s (priority)", path),
+		Status:  pkgmirror.STATUS_RUNNING,
+	}
+
+	err := gs.Fetch(path)
+
+	gs.recordFetch(path, err)
+
+	if err != nil {
+		gs.StateChan <- pkgmirror.State{
+			Message: fmt.Sprintf("Error while fetching %s: %s", path, err),
+			Status:  pkgmirror.STATUS_ERROR,
+		}
+	} else {
 		gs.StateChan <- pkgmirror.State{
-			Message: fmt.Sprintf("Fetch %s", path[len(service):]),
-			Status:  pkgmirror.STATUS_RUNNING,
+			Message: fmt.Sprintf("Fetched %s (priority)", path),
+			Status:  pkgmirror.STATUS_HOLD,
 		}
+	}
+}
 
-		logger.Info("Sync repository")
+// recordFetch stores the result of a fetch in the repository state.
+func (gs *GitService) recordFetch(path string, fetchErr error) {
+	if fetchErr == pkgmirror.SyncInProgressError {
+		return // another fetch is running, it will record its own result
+	}
 
-		cmd := exec.Command(gs.Config.Binary, "fetch")
-		cmd.Dir = path
+	logger := gs.Logger.WithFields(log.Fields{
+		"path":   path,
+		"action": "recordFetch",
+	})
 
-		if err := cmd.Start(); err != nil {
-			logger.WithError(err).Error("Error while starting the fetch command")
+	err := gs.updateRepository(path, func(repo *Repository) {
+		if fetchErr != nil {
+			repo.Fail(fetchErr, time.Now(), time.Minute, gs.Config.FetchBackoff)
+		} else {
+			repo.Schedule(time.Now(), gs.Config.FetchMinInterval, gs.Config.FetchMaxInterval)
+			repo.Succeed(time.Now())
+		}
+	})
 
-			continue
+	if err != nil {
+		logger.WithError(err).Error("Unable to save the repository state")
+	}
+}
+
+func (gs *GitService) Fetch(path string) error {
+	logger := gs.Logger.WithFields(log.Fields{
+		"path":   path,
+		"action": "Fetch",
+	})
+
+	if !gs.lockRepository(path) {
+		logger.Debug("A fetch is already running")
+
+		return pkgmirror.SyncInProgressError
+	}
+
+	defer gs.unlockRepository(path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), gs.Config.FetchTimeout)
+	defer cancel()
+
+	dir := gs.dataFolder() + string(filepath.Separator) + path
+
+	before, err := gs.Backend.Refs(dir)
+	if err != nil {
+		logger.WithError(err).Error("Error while reading the refs")
+
+		return err
+	}
+
+	if gs.Config.Type == "svn" {
+		err = gs.runRemoteCommand(ctx, dir, "svn", "fetch")
+	} else {
+		err = gs.Backend.Fetch(ctx, dir, &FetchOptions{Prune: gs.Config.Prune, RefSpecs: gs.fetchRefSpecs(path)})
+	}
+
+	if err != nil {
+		logger.WithError(err).Error("Error while fetching the remote repository")
+
+		return err
+	}
+
+	logger.Debug("Complete the fetch command")
+
+	if gs.Config.Type == "svn" {
+		if err := gs.updateSvnRefs(dir); err != nil {
+			logger.WithError(err).Error("Error while updating the svn refs")
+
+			return err
 		}
+	} else if gs.Config.ImmutableTags {
+		if err := gs.preserveTags(dir); err != nil {
+			logger.WithError(err).Error("Error while updating the tags")
 
-		if err := cmd.Wait(); err != nil {
-			logger.WithError(err).Error("Error while waiting the fetch command")
+			return err
+		}
+	}
 
-			continue
+	if after, err := gs.Backend.Refs(dir); err != nil {
+		logger.WithError(err).Error("Error while reading the refs")
+	} else if changes := diffRefs(before, after, gs.isAncestor(dir), time.Now()); len(changes) > 0 {
+		if err := gs.recordRefChanges(path, changes); err != nil {
+			logger.WithError(err).Error("Unable to save the ref changes")
 		}
 
+		gs.updateRepository(path, func(repo *Repository) {
+			repo.LastChangeAt = time.Now()
+		})
+	}
+
+	gs.afterUpdate(path)
+
+	return nil
+}
+
+// lockRepository returns false if a fetch or a maintenance task is already
+// running on the repository.
+func (gs *GitService) lockRepository(path string) bool {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	if gs.fetching[path] {
+		return false
+	}
+
+	gs.fetching[path] = true
+
+	return true
+}
+
+func (gs *GitService) unlockRepository(path string) {
+	gs.lock.Lock()
+	delete(gs.fetching, path)
+	gs.lock.Unlock()
+}
+
+// afterUpdate runs the optional tasks once a repository has been cloned or fetched.
+func (gs *GitService) afterUpdate(path string) {
+	if gs.Config.Lfs {
+		gs.fetchLfs(path)
+	}
+
+	if gs.Config.Submodules {
+		gs.mirrorSubmodules(path)
+	}
+}
+
+func (gs *GitService) GetRepository(path string) (*Repository, error) {
+	repo := &Repository{Path: path}
+
+	err := gs.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(gs.Config.Code)
+
+		data := b.Get([]byte(path))
+
+		if len(data) == 0 {
+			return nil
+		}
+
+		return json.Unmarshal(data, repo)
+	})
+
+	return repo, err
+}
+
+// Repositories returns the stored state of every fetched repository.
+func (gs *GitService) Repositories() ([]*Repository, error) {
+	repos := []*Repository{}
+
+	err := gs.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(gs.Config.Code)
+
+		return b.ForEach(func(k, v []byte) error {
+			repo := &Repository{}
+
+			if err := json.Unmarshal(v, repo); err != nil {
+				return err
+			}
+
+			repos = append(repos, repo)
+
+			return nil
+		})
+	})
+
+	return repos, err
+}
+
+// updateRepository loads, updates and saves the repository state in a single
+// transaction, so concurrent updates of the same repository are not lost.
+func (gs *GitService) updateRepository(path string, fn func(repo *Repository)) error {
+	return gs.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(gs.Config.Code)
+
+		repo := &Repository{Path: path}
+
+		if data := b.Get([]byte(path)); len(data) > 0 {
+			if err := json.Unmarshal(data, repo); err != nil {
+				return err
+			}
+		}
+
+		fn(repo)
+
+		data, err := json.Marshal(repo)
+
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(path), data)
+	})
+}
+
+// ResolveRef returns the commit id of a branch, a tag or a commit.
+func (gs *GitService) ResolveRef(path, ref string) (string, error) {
+	if err := gs.restoreArchivedRepository(path); err != nil {
+		return "", err
+	}
+
+	if !gs.Has(path) {
+		return "", pkgmirror.ResourceNotFoundError
+	}
+
+	dir := gs.dataFolder() + string(filepath.Separator) + path
+
+	var commit string
+	var err error
+
+	if results := SVN_REVISION_REF.FindStringSubmatch(ref); gs.Config.Type == "svn" && len(results) > 0 {
+		commit, err = gs.svnFindRev(dir, results[1], results[2])
+	} else {
+		commit, err = gs.Backend.RevParse(dir, ref)
+	}
+
+	if err != nil {
 		gs.Logger.WithFields(log.Fields{
 			"path":   path,
-			"action": "SyncRepositories",
-		}).Debug("Complete the fetch command")
+			"ref":    ref,
+			"action": "ResolveRef",
+		}).WithError(err).Info("Unable to resolve the reference")
+
+		return "", err
+	}
+
+	if !COMMIT_ID.MatchString(commit) {
+		return "", pkgmirror.ResourceNotFoundError
 	}
+
+	return commit, nil
 }
 
-func (gs *GitService) WriteArchive(w io.Writer, path, ref string) error {
-	if CACHEABLE_REF.Match([]byte(ref)) {
-		return gs.cacheArchive(w, path, ref)
-	} else {
-		return gs.writeArchive(w, path, ref)
+// WriteArchive writes the archive of the commit, the commit must be resolved
+// with ResolveRef. The requested ref is only used to build the archive prefix.
+// Archives are cached by commit, so a branch archive is generated again only
+// once the branch moves.
+func (gs *GitService) WriteArchive(w io.Writer, path, ref, commit, format string) error {
+	if _, ok := ARCHIVE_FORMATS[format]; !ok {
+		return pkgmirror.InvalidArchiveFormatError
+	}
+
+	if !COMMIT_ID.MatchString(commit) {
+		return pkgmirror.ResourceNotFoundError
+	}
+
+	return gs.cacheArchive(w, path, ref, commit, format)
+}
+
+func (gs *GitService) archiveKey(path, ref, commit, format string) string {
+	if gs.Config.ArchivePrefix {
+		// the content depends on the prefix
+		return fmt.Sprintf("%s:%s/%s@%s.%s", gs.Config.Server, path, commit, strings.TrimSuffix(archivePrefix(path, ref), "/"), format)
+	}
+
+	return fmt.Sprintf("%s:%s/%s.%s", gs.Config.Server, path, commit, format)
+}
+
+// ArchiveETag returns the ETag of the archive, like the vault key it depends on
+// the prefix when the archives are prefixed.
+func (gs *GitService) ArchiveETag(path, ref, commit string) string {
+	if gs.Config.ArchivePrefix {
+		return fmt.Sprintf(`"%s-%x"`, commit, sha1.Sum([]byte(archivePrefix(path, ref))))
 	}
+
+	return fmt.Sprintf(`"%s"`, commit)
 }
 
-func (gs *GitService) cacheArchive(w io.Writer, path, ref string) error {
+func (gs *GitService) cacheArchive(w io.Writer, path, ref, commit, format string) error {
 	logger := gs.Logger.WithFields(log.Fields{
 		"path":   path,
 		"ref":    ref,
+		"commit": commit,
+		"format": format,
 		"action": "cacheArchive",
 	})
 
-	vaultKey := fmt.Sprintf("%s:%s/%s", gs.Config.Server, path, ref)
+	vaultKey := gs.archiveKey(path, ref, commit, format)
 
-	if !gs.Vault.Has(vaultKey) {
-		logger.Info("Create vault entry")
+	if err := gs.queueArchive(vaultKey, path, ref, commit, format); err != nil {
+		return err
+	}
 
-		var wg sync.WaitGroup
+	logger.Info("Read vault entry")
+	if _, err := gs.Vault.Get(vaultKey, w); err != nil {
+		return err
+	}
 
-		pr, pw := io.Pipe()
-		wg.Add(1)
+	return nil
+}
 
-		go func() {
-			meta := vault.NewVaultMetadata()
-			meta["path"] = path
-			meta["ref"] = ref
+// putArchive generates the archive into the vault.
+func (gs *GitService) putArchive(vaultKey, path, ref, commit, format string) error {
+	logger := gs.Logger.WithFields(log.Fields{
+		"path":   path,
+		"ref":    ref,
+		"commit": commit,
+		"format": format,
+		"action": "putArchive",
+	})
 
-			if _, err := gs.Vault.Put(vaultKey, meta, pr); err != nil {
-				logger.WithError(err).Info("Error while writing into vault")
+	logger.Info("Create vault entry")
 
-				gs.Vault.Remove(vaultKey)
-			}
+	var wg sync.WaitGroup
 
-			wg.Done()
-		}()
+	pr, pw := io.Pipe()
+	wg.Add(1)
 
-		if err := gs.writeArchive(pw, path, ref); err != nil {
-			logger.WithError(err).Info("Error while writing archive")
+	go func() {
+		meta := vault.NewVaultMetadata()
+		meta["path"] = path
+		meta["ref"] = ref
+		meta["commit"] = commit
+		meta["format"] = format
 
-			pw.Close()
-			pr.Close()
+		if _, err := gs.Vault.Put(vaultKey, meta, pr); err != nil {
+			logger.WithError(err).Info("Error while writing into vault")
 
 			gs.Vault.Remove(vaultKey)
-
-			return err
-		} else {
-			pw.Close()
 		}
 
-		wg.Wait()
+		wg.Done()
+	}()
+
+	if err := gs.writeArchive(pw, path, ref, commit, format); err != nil {
+		logger.WithError(err).Info("Error while writing archive")
 
+		pw.Close()
 		pr.Close()
-	}
 
-	logger.Info("Read vault entry")
-	if _, err := gs.Vault.Get(vaultKey, w); err != nil {
+		gs.Vault.Remove(vaultKey)
+
 		return err
+	} else {
+		pw.Close()
 	}
 
+	wg.Wait()
+
+	pr.Close()
+
 	return nil
 }
 
@@ -239,29 +752,37 @@ func (gs *GitService) dataFolder() string {
 	return gs.Config.DataDir + string(filepath.Separator) + gs.Config.Server
 }
 
-func (gs *GitService) writeArchive(w io.Writer, path, ref string) error {
+// archivePrefix returns the top level folder of an archive, following the
+// GitHub layout: repository-ref/
+func archivePrefix(path, ref string) string {
+	name := strings.TrimSuffix(filepath.Base(path), ".git")
+
+	return fmt.Sprintf("%s-%s/", name, strings.Replace(ref, "/", "-", -1))
+}
+
+func (gs *GitService) writeArchive(w io.Writer, path, ref, commit, format string) error {
 	logger := gs.Logger.WithFields(log.Fields{
 		"path":   path,
+		"commit": commit,
+		"format": format,
 		"action": "writeArchive",
 	})
 
-	cmd := exec.Command(gs.Config.Binary, "archive", "--format=zip", ref)
-	cmd.Dir = gs.dataFolder() + string(filepath.Separator) + path
-
-	stdout, _ := cmd.StdoutPipe()
-
-	if err := cmd.Start(); err != nil {
-		logger.WithError(err).Error("Error while starting the archive command")
+	if gs.Config.ArchiveSubmodules {
+		if submodules, err := gs.Submodules(path, commit); err == nil && len(submodules) > 0 {
+			logger.Debug("Include submodules into the archive")
 
-		return err
+			return gs.writeArchiveWithSubmodules(w, path, ref, commit, format)
+		}
 	}
 
-	if _, err := io.Copy(w, stdout); err != nil {
-		logger.WithError(err).Error("Error while reading stdout from the archive command")
+	prefix := ""
+	if gs.Config.ArchivePrefix {
+		prefix = archivePrefix(path, ref)
 	}
 
-	if err := cmd.Wait(); err != nil {
-		logger.WithError(err).Error("Error while waiting the archive command")
+	if err := gs.Backend.Archive(w, gs.dataFolder()+string(filepath.Separator)+path, commit, format, prefix); err != nil {
+		logger.WithError(err).Error("Error while generating the archive")
 
 		return err
 	}
@@ -288,9 +809,174 @@ func (gs *GitService) Has(path string) bool {
 	return has
 }
 
+// EnsureRepository clones the repository if it is not available yet. The clone
+// goes through the clone queue, concurrent calls for the same path wait for the
+// same clone to complete.
+func (gs *GitService) EnsureRepository(path string) error {
+	if !validRepositoryPath(path) {
+		return pkgmirror.ResourceNotFoundError
+	}
+
+	if err := gs.restoreArchivedRepository(path); err != nil {
+		return err
+	}
+
+	if !gs.CanClone(path) {
+		return pkgmirror.CloneForbiddenError
+	}
+
+	gs.lock.Lock()
+
+	job, ok := gs.clones[path]
+
+	if !ok {
+		if gs.Has(path) {
+			gs.lock.Unlock()
+
+			return nil
+		}
+
+		job = &cloneJob{Path: path, Done: make(chan struct{})}
+		gs.clones[path] = job
+	}
+
+	gs.lock.Unlock()
+
+	if !ok {
+		gs.Logger.WithFields(log.Fields{
+			"path":   path,
+			"action": "EnsureRepository",
+		}).Debug("Queue clone")
+
+		gs.cloneQueue <- job
+	}
+
+	<-job.Done
+
+	return job.Error
+}
+
+// PrepareRepository makes the repository available to a client: the repository
+// is cloned if needed, and the access is recorded. The clone restrictions are
+// checked before the rate limit, so a rejected request does not use the
+// client's budget.
+func (gs *GitService) PrepareRepository(path, client string) error {
+	if err := gs.restoreArchivedRepository(path); err != nil {
+		return err
+	}
+
+	if len(gs.Config.Clone) == 0 {
+		gs.Touch(path)
+
+		return nil // not configured, so skip clone
+	}
+
+	logger := gs.Logger.WithFields(log.Fields{
+		"path":   path,
+		"client": client,
+		"action": "PrepareRepository",
+	})
+
+	if !gs.Has(path) {
+		if !validRepositoryPath(path) {
+			return pkgmirror.ResourceNotFoundError
+		}
+
+		if !gs.CanClone(path) {
+			return pkgmirror.CloneForbiddenError
+		}
+
+		if !gs.AllowClient(client, time.Now()) {
+			logger.Warn("Clone rate limit reached")
+
+			return pkgmirror.RateLimitError
+		}
+	}
+
+	// clone the repository if not available, or wait for the running clone
+	if err := gs.EnsureRepository(path); err != nil {
+		logger.WithError(err).Error("Unable to clone the repository")
+
+		return err
+	}
+
+	gs.Touch(path)
+
+	return nil
+}
+
+// CanClone checks the path against the deny and allow glob patterns, deny
+// patterns win and an empty allow list allows any path.
+func (gs *GitService) CanClone(path string) bool {
+	for _, pattern := range gs.Config.CloneDeny {
+		if matched, _ := filepath.Match(pattern, path); matched {
+			return false
+		}
+	}
+
+	if len(gs.Config.CloneAllow) == 0 {
+		return true
+	}
+
+	for _, pattern := range gs.Config.CloneAllow {
+		if matched, _ := filepath.Match(pattern, path); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowClient records a clone request from the client, and returns false if
+// the client reached the clone rate limit over the last hour.
+func (gs *GitService) AllowClient(client string, now time.Time) bool {
+	if gs.Config.CloneRateLimit <= 0 {
+		return true
+	}
+
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	recent := []time.Time{}
+	for _, t := range gs.clients[client] {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+
+	if len(recent) >= gs.Config.CloneRateLimit {
+		gs.clients[client] = recent
+
+		return false
+	}
+
+	gs.clients[client] = append(recent, now)
+
+	return true
+}
+
+func (gs *GitService) cloneWorker() {
+	for job := range gs.cloneQueue {
+		job.Error = gs.Clone(job.Path)
+
+		gs.lock.Lock()
+		delete(gs.clones, job.Path)
+		gs.lock.Unlock()
+
+		close(job.Done)
+	}
+}
+
+// Clone mirrors the remote repository. The repository is cloned in a temporary
+// folder and moved to its final location once completed, so a failed or a
+// timed out clone does not leave a half cloned repository behind.
 func (gs *GitService) Clone(path string) error {
 	gitPath := gs.dataFolder() + string(filepath.Separator) + path
+	tmpPath := gitPath + ".pkgmirror-clone"
 	remote := strings.Replace(gs.Config.Clone, "{path}", path, -1)
+	if gs.Config.Type == "svn" {
+		remote = gs.svnRemote(path)
+	}
 
 	if gs.Config.Clone == remote {
 		// same key, no replacement
@@ -300,54 +986,83 @@ func (gs *GitService) Clone(path string) error {
 	logger := gs.Logger.WithFields(log.Fields{
 		"path":   path,
 		"action": "Clone",
-		"remote": remote,
+		"remote": RedactUrl(remote),
 	})
 
 	logger.Info("Starting cloning remote repository")
 
-	cmd := exec.Command(gs.Config.Binary, "clone", "--mirror", remote, gitPath)
+	os.RemoveAll(tmpPath)
 
-	logger.WithField("cmd", cmd.Args).Debug("Run command")
+	ctx, cancel := context.WithTimeout(context.Background(), gs.Config.CloneTimeout)
+	defer cancel()
 
-	if err := cmd.Start(); err != nil {
-		logger.WithError(err).Error("Error while starting to clone the remote repository")
+	var err error
+	if gs.Config.Type == "svn" {
+		err = gs.cloneSvn(ctx, remote, tmpPath)
+	} else {
+		err = gs.Backend.Clone(ctx, remote, tmpPath, gs.cloneOptions(path))
+	}
+
+	if err != nil {
+		logger.WithError(err).Error("Error while cloning the remote repository")
+
+		os.RemoveAll(tmpPath)
 
 		return err
 	}
 
-	if err := cmd.Wait(); err != nil {
-		logger.WithError(err).Error("Error while cloning the remote repository")
+	if gs.Config.CloneMaxSize > 0 {
+		if size, err := dirSize(tmpPath); err != nil || size > gs.Config.CloneMaxSize {
+			logger.WithField("size", size).Warn("The repository exceeds the maximum size")
+
+			os.RemoveAll(tmpPath)
+
+			return pkgmirror.RepositoryTooLargeError
+		}
+	}
+
+	if err := os.Rename(tmpPath, gitPath); err != nil {
+		logger.WithError(err).Error("Error while moving the cloned repository")
+
+		os.RemoveAll(tmpPath)
 
 		return err
 	}
 
+	logger.Info("Repository cloned")
+
+	gs.afterUpdate(path)
+
 	return nil
 }
 
-func GitRewriteArchive(publicServer, path string) string {
-	if results := GITHUB_ARCHIVE.FindStringSubmatch(path); len(results) == 6 {
-		return fmt.Sprintf("%s/git/%s/%s/%s/%s.zip", publicServer, results[2], results[3], results[4], results[5])
-	}
+// dirSize returns the disk usage of the folder.
+func dirSize(path string) (int64, error) {
+	var size int64
 
-	if results := BITBUCKET_ARCHIVE.FindStringSubmatch(path); len(results) == 6 {
-		return fmt.Sprintf("%s/git/%s/%s/%s/%s.zip", publicServer, results[2], results[3], results[4], results[5])
-	}
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 
-	if results := GITLAB_ARCHIVE.FindStringSubmatch(path); len(results) == 6 {
-		return fmt.Sprintf("%s/git/%s/%s/%s/%s.zip", publicServer, results[2], results[3], results[4], results[5])
-	}
+		if !info.IsDir() {
+			size += info.Size()
+		}
 
-	return publicServer
+		return nil
+	})
+
+	return size, err
 }
 
-func GitRewriteRepository(publicServer, path string) string {
-	if results := SVN_REPOSITORY.FindStringSubmatch(path); len(results) > 1 {
-		return path // svn not supported
-	}
+var defaultRewriter = NewRewriter()
 
-	if results := GIT_REPOSITORY.FindStringSubmatch(path); len(results) > 1 {
-		return fmt.Sprintf("%s/git/%s/%s.git", publicServer, results[6], results[8])
-	}
+// GitRewriteArchive rewrites the archive url with the default rules.
+func GitRewriteArchive(publicServer, path string) string {
+	return defaultRewriter.Archive(publicServer, path)
+}
 
-	return publicServer
+// GitRewriteRepository rewrites the repository url with the default rules.
+func GitRewriteRepository(publicServer, path string) string {
+	return defaultRewriter.Repository(publicServer, path)
 }
@@ -6,9 +6,13 @@
 package git
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"regexp"
+	"time"
 
 	"github.com/AaronO/go-git-http"
 	log "github.com/Sirupsen/logrus"
@@ -37,6 +41,12 @@ func ConfigureApp(config *pkgmirror.Config, l *goapp.Lifecycle) {
 				continue
 			}
 
+			switch conf.UnusedAction {
+			case "", "skip", "archive", "remove":
+			default:
+				return fmt.Errorf("Invalid unused action for the git mirror %s: %s", name, conf.UnusedAction)
+			}
+
 			app.Set(fmt.Sprintf("pkgmirror.git.%s", name), func(name string, conf *pkgmirror.GitConfig) func(app *goapp.App) interface{} {
 
 				return func(app *goapp.App) interface{} {
@@ -45,6 +55,104 @@ func ConfigureApp(config *pkgmirror.Config, l *goapp.Lifecycle) {
 					s.Config.PublicServer = config.PublicServer
 					s.Config.DataDir = fmt.Sprintf("%s/git", config.DataDir)
 					s.Config.Clone = conf.Clone
+					s.Config.Code = []byte(name)
+					s.Config.WebhookSecret = conf.WebhookSecret
+					s.Config.ArchivePrefix = conf.ArchivePrefix
+					s.Config.Lfs = conf.Lfs
+					s.Config.Submodules = conf.Submodules
+					s.Config.ArchiveSubmodules = conf.ArchiveSubmodules
+					s.Config.CloneAllow = conf.CloneAllow
+					s.Config.CloneDeny = conf.CloneDeny
+					s.Config.CloneMaxSize = conf.CloneMaxSize * 1024 * 1024
+					s.Config.CloneRateLimit = conf.CloneRateLimit
+					s.Config.Prune = conf.Prune
+					s.Config.ImmutableTags = conf.ImmutableTags
+					s.Config.UnusedAfter = time.Duration(conf.UnusedAfter) * 24 * time.Hour
+					s.Config.Refs = conf.Refs
+					s.Config.Filter = conf.Filter
+					for _, rule := range conf.Partial {
+						s.Config.Partial = append(s.Config.Partial, &PartialRule{
+							Pattern: rule.Pattern,
+							Refs:    rule.Refs,
+							Filter:  rule.Filter,
+						})
+					}
+					s.Config.Credentials = &Credentials{
+						Username:   conf.Username,
+						Password:   conf.Password,
+						Token:      conf.Token,
+						SshKey:     conf.SshKey,
+						KnownHosts: conf.KnownHosts,
+					}
+					s.Mirrors = func(server string) *GitService {
+						for code, c := range config.Git {
+							if c.Enabled && c.Server == server {
+								return app.Get(fmt.Sprintf("pkgmirror.git.%s", code)).(*GitService)
+							}
+						}
+
+						return nil
+					}
+
+					if conf.FetchWorkers > 0 {
+						s.Config.FetchWorkers = conf.FetchWorkers
+					}
+
+					if conf.FetchTimeout > 0 {
+						s.Config.FetchTimeout = time.Duration(conf.FetchTimeout) * time.Second
+					}
+
+					if conf.FetchBackoff > 0 {
+						s.Config.FetchBackoff = time.Duration(conf.FetchBackoff) * time.Second
+					}
+
+					if conf.CloneWorkers > 0 {
+						s.Config.CloneWorkers = conf.CloneWorkers
+					}
+
+					if conf.CloneTimeout > 0 {
+						s.Config.CloneTimeout = time.Duration(conf.CloneTimeout) * time.Second
+					}
+
+					if conf.FetchMinInterval > 0 {
+						s.Config.FetchMinInterval = time.Duration(conf.FetchMinInterval) * time.Second
+					}
+
+					if conf.FetchMaxInterval > 0 {
+						s.Config.FetchMaxInterval = time.Duration(conf.FetchMaxInterval) * time.Second
+					}
+
+					if conf.GcInterval > 0 {
+						s.Config.GcInterval = time.Duration(conf.GcInterval) * time.Second
+					}
+
+					if len(conf.Type) > 0 {
+						s.Config.Type = conf.Type
+					}
+
+					if len(conf.SvnLayout) > 0 {
+						s.Config.SvnLayout = conf.SvnLayout
+					}
+
+					if conf.ArchiveWorkers > 0 {
+						s.Config.ArchiveWorkers = conf.ArchiveWorkers
+					}
+
+					if conf.ArchiveQueueSize != 0 {
+						s.Config.ArchiveQueueSize = conf.ArchiveQueueSize
+					}
+
+					if len(conf.UnusedAction) > 0 {
+						s.Config.UnusedAction = conf.UnusedAction
+					}
+
+					backend, err := NewBackend(conf.Backend, s.Config)
+					if err != nil {
+						panic(err)
+					}
+
+					s.Backend = backend
+
 					s.Vault = v
 					s.Logger = logger.WithFields(log.Fields{
 						"handler": "git",
@@ -108,10 +216,6 @@ func ConfigureApp(config *pkgmirror.Config, l *goapp.Lifecycle) {
 						//found match
 						s := app.Get(fmt.Sprintf("pkgmirror.git.%s", name)).(*GitService)
 
-						if len(s.Config.Clone) == 0 {
-							break // not configured, so skip clone
-						}
-
 						expression := fmt.Sprintf(`/git/%s/((.*)\.git)(|.*)`, conf.Server)
 
 						l := logger.WithFields(log.Fields{
@@ -132,15 +236,21 @@ func ConfigureApp(config *pkgmirror.Config, l *goapp.Lifecycle) {
 							break // not valid
 						}
 
-						if s.Has(path) { // repository exists, nothing to do
-							l.Debug("Skipping cloning, repository exist")
-
-							break
-						}
-
-						// not available, clone the repository
-						if err := s.Clone(path); err != nil {
-							l.WithError(err).Error("Unable to clone the repository")
+						if err := s.PrepareRepository(path, clientIp(r)); err != nil {
+							l.WithError(err).Warn("Unable to prepare the repository")
+
+							switch err {
+							case pkgmirror.RateLimitError:
+								pkgmirror.SendWithHttpCode(w, 429, err.Error())
+							case pkgmirror.CloneForbiddenError, pkgmirror.RepositoryTooLargeError:
+								pkgmirror.SendWithHttpCode(w, 403, err.Error())
+							case pkgmirror.ResourceNotFoundError:
+								pkgmirror.SendWithHttpCode(w, 404, err.Error())
+							default:
+								pkgmirror.SendWithHttpCode(w, 500, err.Error())
+							}
+
+							return
 						}
 
 						break
@@ -177,6 +287,66 @@ func ConfigureApp(config *pkgmirror.Config, l *goapp.Lifecycle) {
 			}
 		}(name))
 	}
+
+	if config.GitSsh != nil && config.GitSsh.Enabled {
+		l.Run(func(app *goapp.App, state *goapp.GoroutineState) error {
+			logger := app.Get("logger").(*log.Logger).WithFields(log.Fields{
+				"handler": "git-ssh",
+				"listen":  config.GitSsh.Listen,
+			})
+
+			sshConfig, err := NewSshServerConfig(config.GitSsh.HostKey, config.GitSsh.AuthorizedKeys, logger)
+			if err != nil {
+				logger.WithError(err).Error("Unable to configure the ssh server")
+
+				return err
+			}
+
+			listener, err := net.Listen("tcp", config.GitSsh.Listen)
+			if err != nil {
+				logger.WithError(err).Error("Unable to start the ssh server")
+
+				return err
+			}
+
+			server := &SshServer{
+				Config: sshConfig,
+				Logger: logger,
+				Mirror: func(server string) *GitService {
+					for code, c := range config.Git {
+						if c.Enabled && c.Server == server {
+							return app.Get(fmt.Sprintf("pkgmirror.git.%s", code)).(*GitService)
+						}
+					}
+
+					return nil
+				},
+			}
+
+			logger.Info("Start ssh server")
+
+			done := make(chan error, 1)
+
+			go func() {
+				done <- server.Serve(listener)
+			}()
+
+			select {
+			case <-state.In:
+				logger.Info("Stop ssh server")
+
+				listener.Close()
+				<-done
+
+				return nil
+
+			case err := <-done:
+				logger.WithError(err).Error("Ssh server stopped")
+
+				return err
+			}
+		})
+	}
 }
 
 func ConfigureHttp(name string, conf *pkgmirror.GitConfig, app *goapp.App) {
@@ -184,10 +354,135 @@ func ConfigureHttp(name string, conf *pkgmirror.GitConfig, app *goapp.App) {
 
 	mux := app.Get("mux").(*goji.Mux)
 
+	for _, provider := range WebhookProviders {
+		mux.HandleFuncC(pat.Post(fmt.Sprintf("/git/%s/hooks/%s", conf.Server, provider)), func(provider string) func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					pkgmirror.SendWithHttpCode(w, 400, err.Error())
+
+					return
+				}
+
+				if !ValidateWebhook(provider, gitService.Config.WebhookSecret, r, body) {
+					pkgmirror.SendWithHttpCode(w, 403, "Invalid webhook signature")
+
+					return
+				}
+
+				path, err := WebhookRepositoryPath(provider, body)
+				if err != nil {
+					pkgmirror.SendWithHttpCode(w, 400, "Unable to find the repository in the payload")
+
+					return
+				}
+
+				if !gitService.Has(path) {
+					pkgmirror.SendWithHttpCode(w, 404, fmt.Sprintf("Repository %s is not mirrored", path))
+
+					return
+				}
+
+				if gitService.EnqueueFetch(path) {
+					pkgmirror.SendWithHttpCode(w, 202, fmt.Sprintf("Fetch queued for %s", path))
+				} else {
+					pkgmirror.SendWithHttpCode(w, 200, fmt.Sprintf("Fetch already queued for %s", path))
+				}
+			}
+		}(provider))
+	}
+
+	mux.HandleFuncC(NewLfsPat(conf.Server), func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		path, object := pat.Param(ctx, "path"), pat.Param(ctx, "object")
+
+		if !gitService.Config.Lfs || !gitService.Has(path) {
+			pkgmirror.SendWithHttpCode(w, 404, pkgmirror.ResourceNotFoundError.Error())
+
+			return
+		}
+
+		gitService.Touch(path)
+
+		if object != "batch" {
+			if file, err := gitService.LfsObjectPath(object); err != nil {
+				pkgmirror.SendWithHttpCode(w, 404, err.Error())
+			} else {
+				w.Header().Set("Content-Type", "application/octet-stream")
+
+				http.ServeFile(w, r, file)
+			}
+
+			return
+		}
+
+		if r.Method != "POST" {
+			pkgmirror.SendWithHttpCode(w, 405, "Method not allowed")
+
+			return
+		}
+
+		req := &LfsBatchRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			pkgmirror.SendWithHttpCode(w, 400, err.Error())
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/vnd.git-lfs+json")
+
+		if req.Operation != "download" {
+			w.WriteHeader(403)
+			pkgmirror.Serialize(w, &LfsError{Code: 403, Message: "The mirror is read only"})
+
+			return
+		}
+
+		pkgmirror.Serialize(w, gitService.LfsBatch(path, req))
+	})
+
 	mux.HandleFuncC(NewGitPat(conf.Server), func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/zip")
-		if err := gitService.WriteArchive(w, fmt.Sprintf("%s.git", pat.Param(ctx, "path")), pat.Param(ctx, "ref")); err != nil {
+		path, ref, format := fmt.Sprintf("%s.git", pat.Param(ctx, "path")), pat.Param(ctx, "ref"), pat.Param(ctx, "format")
+
+		commit, err := gitService.ResolveRef(path, ref)
+		if err == pkgmirror.ResourceNotFoundError {
+			pkgmirror.SendWithHttpCode(w, 404, err.Error())
+
+			return
+		}
+
+		if err != nil {
+			pkgmirror.SendWithHttpCode(w, 500, err.Error())
+
+			return
+		}
+
+		gitService.Touch(path)
+
+		etag := gitService.ArchiveETag(path, ref, commit)
+
+		w.Header().Set("ETag", etag)
+
+		if match := r.Header.Get("If-None-Match"); match == etag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", ARCHIVE_FORMATS[format])
+		if err := gitService.WriteArchive(w, path, ref, commit, format); err == pkgmirror.ArchiveQueueFullError {
+			w.Header().Set("Retry-After", "60")
+
+			pkgmirror.SendWithHttpCode(w, 503, err.Error())
+		} else if err != nil {
 			pkgmirror.SendWithHttpCode(w, 500, err.Error())
 		}
 	})
 }
+
+func clientIp(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+
+	return r.RemoteAddr
+}
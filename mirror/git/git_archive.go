@@ -0,0 +1,111 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rande/pkgmirror"
+)
+
+// ArchiveStats reports the archive generation queue of a mirror.
+type ArchiveStats struct {
+	Workers int
+	Running int
+	Queued  int
+}
+
+type archiveJob struct {
+	Done  chan struct{}
+	Error error
+}
+
+// archiveQueue limits the number of archives generated at the same time, the
+// requests for an archive already in progress wait for the running job.
+type archiveQueue struct {
+	jobs    map[string]*archiveJob
+	slots   chan struct{}
+	queued  int
+	running int
+	lock    sync.Mutex
+}
+
+// queueArchive generates the archive into the vault if it is not available,
+// the error is ArchiveQueueFullError if too many archives are waiting for a
+// worker.
+func (gs *GitService) queueArchive(vaultKey, path, ref, commit, format string) error {
+	q := gs.archives
+
+	q.lock.Lock()
+
+	if job, ok := q.jobs[vaultKey]; ok {
+		q.lock.Unlock()
+
+		<-job.Done
+
+		return job.Error
+	}
+
+	if gs.Vault.Has(vaultKey) {
+		q.lock.Unlock()
+
+		return nil
+	}
+
+	if gs.Config.ArchiveQueueSize > 0 && q.queued >= gs.Config.ArchiveQueueSize {
+		q.lock.Unlock()
+
+		gs.Logger.WithFields(log.Fields{
+			"path":   path,
+			"commit": commit,
+			"action": "queueArchive",
+		}).Warn("The archive queue is full")
+
+		return pkgmirror.ArchiveQueueFullError
+	}
+
+	job := &archiveJob{Done: make(chan struct{})}
+	q.jobs[vaultKey] = job
+	q.queued++
+
+	q.lock.Unlock()
+
+	q.slots <- struct{}{}
+
+	q.lock.Lock()
+	q.queued--
+	q.running++
+	q.lock.Unlock()
+
+	job.Error = gs.putArchive(vaultKey, path, ref, commit, format)
+
+	<-q.slots
+
+	q.lock.Lock()
+	q.running--
+	delete(q.jobs, vaultKey)
+	q.lock.Unlock()
+
+	close(job.Done)
+
+	return job.Error
+}
+
+// ArchiveStats returns the number of archives being generated and waiting for
+// a worker.
+func (gs *GitService) ArchiveStats() *ArchiveStats {
+	q := gs.archives
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return &ArchiveStats{
+		Workers: gs.Config.ArchiveWorkers,
+		Running: q.running,
+		Queued:  q.queued,
+	}
+}
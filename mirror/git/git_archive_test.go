@@ -0,0 +1,140 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rande/gonode/core/vault"
+	"github.com/rande/pkgmirror"
+	"github.com/stretchr/testify/assert"
+)
+
+type blockingBackend struct {
+	BinaryBackend
+	calls   int32
+	release chan struct{}
+}
+
+func (b *blockingBackend) Archive(w io.Writer, dir, commit, format, prefix string) error {
+	atomic.AddInt32(&b.calls, 1)
+
+	<-b.release
+
+	_, err := w.Write([]byte(commit))
+
+	return err
+}
+
+func newArchiveService(t *testing.T, workers, queueSize int) (*GitService, *blockingBackend, func()) {
+	dir, _ := ioutil.TempDir("", "pkgmirror-archive")
+
+	backend := &blockingBackend{release: make(chan struct{})}
+
+	gs := NewGitService()
+	gs.Logger = log.NewEntry(log.New())
+	gs.Backend = backend
+	gs.Config.ArchiveWorkers = workers
+	gs.Config.ArchiveQueueSize = queueSize
+	gs.Vault = &vault.Vault{Algo: "no_op", Driver: &vault.DriverFs{Root: dir}}
+	gs.archives.slots = make(chan struct{}, workers)
+
+	return gs, backend, func() { os.RemoveAll(dir) }
+}
+
+func waitArchiveStats(t *testing.T, gs *GitService, running, queued int) {
+	for i := 0; i < 100; i++ {
+		if stats := gs.ArchiveStats(); stats.Running == running && stats.Queued == queued {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Fail(t, "unexpected archive stats", "%+v", gs.ArchiveStats())
+}
+
+func Test_Archive_Deduplicate(t *testing.T) {
+	gs, backend, clean := newArchiveService(t, 2, 10)
+	defer clean()
+
+	commit := "9b9cc9573693611badb397b5d01a1e6645704da7"
+	outputs := []*bytes.Buffer{bytes.NewBuffer([]byte("")), bytes.NewBuffer([]byte(""))}
+
+	var wg sync.WaitGroup
+	for _, buf := range outputs {
+		wg.Add(1)
+
+		go func(buf *bytes.Buffer) {
+			assert.NoError(t, gs.WriteArchive(buf, "foo.git", "master", commit, "zip"))
+
+			wg.Done()
+		}(buf)
+	}
+
+	waitArchiveStats(t, gs, 1, 0)
+	close(backend.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), backend.calls)
+	assert.Equal(t, commit, outputs[0].String())
+	assert.Equal(t, commit, outputs[1].String())
+}
+
+func Test_Archive_QueueFull(t *testing.T) {
+	gs, backend, clean := newArchiveService(t, 1, 1)
+	defer clean()
+
+	var wg sync.WaitGroup
+	for _, commit := range []string{"9b9cc9573693611badb397b5d01a1e6645704da7", "2da62f8886014fb045e41b659e4fa83db5ef24d2"} {
+		wg.Add(1)
+
+		go func(commit string) {
+			assert.NoError(t, gs.WriteArchive(ioutil.Discard, "foo.git", "master", commit, "zip"))
+
+			wg.Done()
+		}(commit)
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	waitArchiveStats(t, gs, 1, 1)
+
+	err := gs.WriteArchive(ioutil.Discard, "foo.git", "master", "0000000000000000000000000000000000000000", "zip")
+	assert.Equal(t, pkgmirror.ArchiveQueueFullError, err)
+
+	assert.Equal(t, &ArchiveStats{Workers: 1, Running: 1, Queued: 1}, gs.ArchiveStats())
+
+	close(backend.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(2), backend.calls)
+	waitArchiveStats(t, gs, 0, 0)
+}
+
+func Test_Archive_ETag(t *testing.T) {
+	gs := NewGitService()
+
+	commit := "9b9cc9573693611badb397b5d01a1e6645704da7"
+
+	assert.Equal(t, `"9b9cc9573693611badb397b5d01a1e6645704da7"`, gs.ArchiveETag("foo.git", "master", commit))
+	assert.Equal(t, gs.ArchiveETag("foo.git", "master", commit), gs.ArchiveETag("foo.git", "0.0.1", commit))
+
+	// the prefix is part of the content
+	gs.Config.ArchivePrefix = true
+
+	assert.NotEqual(t, gs.ArchiveETag("foo.git", "master", commit), gs.ArchiveETag("foo.git", "0.0.1", commit))
+	assert.Equal(t, gs.ArchiveETag("foo.git", "master", commit), gs.ArchiveETag("foo.git", "master", commit))
+	assert.Contains(t, gs.ArchiveETag("foo.git", "master", commit), commit)
+}
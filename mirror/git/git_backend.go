@@ -0,0 +1,308 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/rande/pkgmirror"
+)
+
+// FetchOptions of a fetch, the refs are fetched with the refspecs of the
+// remote if RefSpecs is empty. Tags are not fetched automatically with custom
+// refspecs.
+type FetchOptions struct {
+	Prune    bool
+	RefSpecs []string
+}
+
+// CloneOptions of a clone, all the refs are cloned if RefSpecs is empty.
+// Filter is a partial clone filter, ie: blob:none
+type CloneOptions struct {
+	RefSpecs []string
+	Filter   string
+}
+
+// Backend runs the git operations on the mirrored repositories, dir is the
+// path of the bare repository on disk.
+type Backend interface {
+	Clone(ctx context.Context, remote, dir string, opts *CloneOptions) error
+	Fetch(ctx context.Context, dir string, opts *FetchOptions) error
+	Archive(w io.Writer, dir, commit, format, prefix string) error
+	RevParse(dir, ref string) (string, error)
+	Refs(dir string) (map[string]string, error)
+	IsAncestor(dir, ancestor, commit string) bool
+	UpdateRefs(dir string, updates map[string]string) error
+	RemoteUrl(dir string) (string, error)
+	DefaultBranch(dir string) (string, error)
+	Submodules(dir, ref string) ([]*Submodule, error)
+	Gc(dir string) error
+}
+
+// NewBackend returns the backend matching the name, binary or go-git.
+func NewBackend(name string, config *GitConfig) (Backend, error) {
+	switch name {
+	case "", "binary":
+		return &BinaryBackend{Config: config}, nil
+	case "go-git":
+		return &GoGitBackend{Config: config}, nil
+	}
+
+	return nil, fmt.Errorf("Invalid git backend: %s", name)
+}
+
+// BinaryBackend runs the git binary, the binary is required for the svn
+// mirrors, lfs, submodules and gc whatever the backend.
+type BinaryBackend struct {
+	Config *GitConfig
+}
+
+func (b *BinaryBackend) command(ctx context.Context, dir string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, b.Config.Binary, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), b.Config.Credentials.Env()...)
+
+	return cmd
+}
+
+func (b *BinaryBackend) run(ctx context.Context, dir string, args ...string) error {
+	if err := b.command(ctx, dir, args...).Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return pkgmirror.CommandTimeoutError
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Clone mirrors the repository, a clone restricted to some refs is initialized
+// with the refspecs and HEAD points to the default branch of the remote. The
+// filter is kept by git for the next fetches.
+func (b *BinaryBackend) Clone(ctx context.Context, remote, dir string, opts *CloneOptions) error {
+	args := []string{}
+	if len(opts.Filter) > 0 {
+		args = append(args, fmt.Sprintf("--filter=%s", opts.Filter))
+	}
+
+	if len(opts.RefSpecs) == 0 {
+		return b.run(ctx, "", append(append([]string{"clone", "--mirror"}, args...), remote, dir)...)
+	}
+
+	if err := b.run(ctx, "", "init", "--bare", dir); err != nil {
+		return err
+	}
+
+	if err := b.run(ctx, dir, "config", "remote.origin.url", remote); err != nil {
+		return err
+	}
+
+	for _, spec := range opts.RefSpecs {
+		if err := b.run(ctx, dir, "config", "--add", "remote.origin.fetch", spec); err != nil {
+			return err
+		}
+	}
+
+	if err := b.run(ctx, dir, append(append([]string{"fetch", "--no-tags"}, args...), "origin")...); err != nil {
+		return err
+	}
+
+	output, err := b.command(ctx, dir, "ls-remote", "--symref", "origin", "HEAD").Output()
+	if err != nil {
+		return err
+	}
+
+	if fields := strings.Fields(string(output)); len(fields) > 2 && fields[0] == "ref:" {
+		return b.run(ctx, dir, "symbolic-ref", "HEAD", fields[1])
+	}
+
+	return nil
+}
+
+func (b *BinaryBackend) Fetch(ctx context.Context, dir string, opts *FetchOptions) error {
+	args := []string{"fetch"}
+
+	if opts.Prune {
+		args = append(args, "--prune")
+	}
+
+	if len(opts.RefSpecs) > 0 {
+		args = append(append(args, "--no-tags", "--refmap=", "origin"), opts.RefSpecs...)
+	}
+
+	return b.run(ctx, dir, args...)
+}
+
+func (b *BinaryBackend) Archive(w io.Writer, dir, commit, format, prefix string) error {
+	args := []string{"archive", fmt.Sprintf("--format=%s", format)}
+
+	if len(prefix) > 0 {
+		args = append(args, fmt.Sprintf("--prefix=%s", prefix))
+	}
+
+	// the missing objects of a partial clone are fetched on demand, so the
+	// archive is bounded by the fetch timeout
+	ctx, cancel := context.WithTimeout(context.Background(), b.Config.FetchTimeout)
+	defer cancel()
+
+	cmd := b.command(ctx, dir, append(args, commit)...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	_, copyErr := io.Copy(w, stdout)
+
+	if copyErr != nil {
+		cmd.Process.Kill() // the output is not read anymore
+	}
+
+	if err := cmd.Wait(); err != nil && copyErr == nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return pkgmirror.CommandTimeoutError
+		}
+
+		return err
+	}
+
+	return copyErr
+}
+
+func (b *BinaryBackend) RevParse(dir, ref string) (string, error) {
+	cmd := exec.Command(b.Config.Binary, "rev-parse", "--verify", "--quiet", fmt.Sprintf("%s^{commit}", ref))
+	cmd.Dir = dir
+
+	output, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.Sys().(syscall.WaitStatus).ExitStatus() == 1 {
+		return "", pkgmirror.ResourceNotFoundError // --verify --quiet exits with 1 on an unknown ref
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (b *BinaryBackend) Refs(dir string) (map[string]string, error) {
+	cmd := exec.Command(b.Config.Binary, "for-each-ref", "--format=%(objectname) %(refname)")
+	cmd.Dir = dir
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseRefs(output), nil
+}
+
+func (b *BinaryBackend) IsAncestor(dir, ancestor, commit string) bool {
+	cmd := exec.Command(b.Config.Binary, "merge-base", "--is-ancestor", ancestor, commit)
+	cmd.Dir = dir
+
+	return cmd.Run() == nil
+}
+
+// UpdateRefs writes the refs in a single update-ref call.
+func (b *BinaryBackend) UpdateRefs(dir string, updates map[string]string) error {
+	if len(updates) == 0 {
+		return nil
+	}
+
+	input := bytes.NewBuffer([]byte(""))
+	for ref, sha := range updates {
+		fmt.Fprintf(input, "update %s %s\n", ref, sha)
+	}
+
+	cmd := exec.Command(b.Config.Binary, "update-ref", "--stdin")
+	cmd.Dir = dir
+	cmd.Stdin = input
+
+	return cmd.Run()
+}
+
+func (b *BinaryBackend) output(dir string, args ...string) (string, error) {
+	cmd := exec.Command(b.Config.Binary, args...)
+	cmd.Dir = dir
+
+	output, err := cmd.Output()
+
+	return strings.TrimSpace(string(output)), err
+}
+
+func (b *BinaryBackend) RemoteUrl(dir string) (string, error) {
+	return b.output(dir, "config", "--get", "remote.origin.url")
+}
+
+func (b *BinaryBackend) DefaultBranch(dir string) (string, error) {
+	return b.output(dir, "symbolic-ref", "--short", "HEAD")
+}
+
+// Submodules returns the submodules declared at the given ref with their
+// commit, the list is empty without .gitmodules file.
+func (b *BinaryBackend) Submodules(dir, ref string) ([]*Submodule, error) {
+	cmd := exec.Command(b.Config.Binary, "config", "--blob", fmt.Sprintf("%s:.gitmodules", ref), "--get-regexp", `^submodule\.`)
+	cmd.Dir = dir
+
+	output, err := cmd.Output()
+	if err != nil {
+		return []*Submodule{}, nil // no .gitmodules file
+	}
+
+	byPath := map[string]*Submodule{}
+	for _, s := range parseSubmodules(output) {
+		byPath[s.Path] = s
+	}
+
+	cmd = exec.Command(b.Config.Binary, "ls-tree", "-r", ref)
+	cmd.Dir = dir
+
+	if output, err = cmd.Output(); err != nil {
+		return nil, err
+	}
+
+	submodules := []*Submodule{}
+
+	// format: 160000 commit 9b9cc9573693611badb397b5d01a1e6645704da7	path/to/submodule
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.Index(line, "\t")
+
+		if i < 0 || !strings.HasPrefix(line, "160000 commit ") {
+			continue
+		}
+
+		if s, ok := byPath[line[i+1:]]; ok {
+			s.Commit = line[len("160000 commit "):i]
+			submodules = append(submodules, s)
+		}
+	}
+
+	return submodules, nil
+}
+
+// Gc packs the loose objects and removes the unreachable ones when needed.
+func (b *BinaryBackend) Gc(dir string) error {
+	cmd := exec.Command(b.Config.Binary, "gc", "--auto", "--quiet")
+	cmd.Dir = dir
+
+	return cmd.Run()
+}
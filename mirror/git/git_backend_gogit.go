@@ -0,0 +1,578 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rande/pkgmirror"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+// gcAutoPackLimit is the default gc.autoPackLimit of git.
+const gcAutoPackLimit = 50
+
+// GoGitBackend runs the git operations in process with go-git, the git binary
+// is not required. Archives are streamed from the object database.
+type GoGitBackend struct {
+	Config *GitConfig
+}
+
+// auth returns the authentication method of the remote, nil if no credentials
+// are configured for its protocol.
+func (b *GoGitBackend) auth(remote string) (transport.AuthMethod, error) {
+	c := b.Config.Credentials
+
+	if c == nil {
+		return nil, nil
+	}
+
+	endpoint, err := transport.NewEndpoint(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	switch endpoint.Protocol {
+	case "http", "https":
+		username, password := c.Username, c.Password
+		if len(c.Token) > 0 {
+			password = c.Token
+
+			if len(username) == 0 {
+				username = "git"
+			}
+		}
+
+		if len(password) == 0 {
+			return nil, nil
+		}
+
+		return &http.BasicAuth{Username: username, Password: password}, nil
+
+	case "ssh":
+		if len(c.SshKey) == 0 {
+			return nil, nil
+		}
+
+		user := endpoint.User
+		if len(user) == 0 {
+			user = "git"
+		}
+
+		auth, err := ssh.NewPublicKeysFromFile(user, c.SshKey, "")
+		if err != nil {
+			return nil, err
+		}
+
+		if len(c.KnownHosts) > 0 {
+			if auth.HostKeyCallback, err = ssh.NewKnownHostsCallback(c.KnownHosts); err != nil {
+				return nil, err
+			}
+		}
+
+		return auth, nil
+	}
+
+	return nil, nil
+}
+
+// Clone creates a bare repository mirroring the refs of the remote, HEAD
+// points to the default branch of the remote. Partial clones are not supported.
+func (b *GoGitBackend) Clone(ctx context.Context, remote, dir string, opts *CloneOptions) error {
+	if len(opts.Filter) > 0 {
+		return fmt.Errorf("Partial clone filters are not supported by the go-git backend")
+	}
+
+	specs := []config.RefSpec{"+refs/*:refs/*"}
+	if len(opts.RefSpecs) > 0 {
+		specs = []config.RefSpec{}
+		for _, spec := range opts.RefSpecs {
+			specs = append(specs, config.RefSpec(spec))
+		}
+	}
+
+	repo, err := gogit.PlainInit(dir, true)
+	if err != nil {
+		return err
+	}
+
+	origin, err := repo.CreateRemote(&config.RemoteConfig{
+		Name:  "origin",
+		URLs:  []string{remote},
+		Fetch: specs,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if err := b.Fetch(ctx, dir, &FetchOptions{RefSpecs: opts.RefSpecs}); err != nil {
+		return err
+	}
+
+	auth, err := b.auth(remote)
+	if err != nil {
+		return err
+	}
+
+	refs, err := origin.List(&gogit.ListOptions{Auth: auth})
+	if err != nil {
+		return err
+	}
+
+	return repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, defaultBranch(refs)))
+}
+
+// defaultBranch returns the branch HEAD points to on the remote, master if it
+// cannot be found.
+func defaultBranch(refs []*plumbing.Reference) plumbing.ReferenceName {
+	var head *plumbing.Reference
+
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD {
+			head = ref
+		}
+	}
+
+	if head == nil {
+		return plumbing.Master
+	}
+
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target()
+	}
+
+	for _, ref := range refs {
+		if ref.Name() == plumbing.Master && ref.Hash() == head.Hash() {
+			return ref.Name()
+		}
+	}
+
+	for _, ref := range refs {
+		if ref.Name().IsBranch() && ref.Hash() == head.Hash() {
+			return ref.Name()
+		}
+	}
+
+	return plumbing.Master
+}
+
+func (b *GoGitBackend) Fetch(ctx context.Context, dir string, opts *FetchOptions) error {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+
+	origin, err := repo.Remote("origin")
+	if err != nil {
+		return err
+	}
+
+	if len(origin.Config().URLs) == 0 {
+		return pkgmirror.MissingRemoteUrlError
+	}
+
+	auth, err := b.auth(origin.Config().URLs[0])
+	if err != nil {
+		return err
+	}
+
+	specs := origin.Config().Fetch
+	tags := gogit.TagFollowing
+
+	if len(opts.RefSpecs) > 0 {
+		specs = []config.RefSpec{}
+		for _, spec := range opts.RefSpecs {
+			specs = append(specs, config.RefSpec(spec))
+		}
+
+		tags = gogit.NoTags
+	}
+
+	err = origin.FetchContext(ctx, &gogit.FetchOptions{
+		RefSpecs: specs,
+		Auth:     auth,
+		Tags:     tags,
+		Force:    true,
+	})
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return pkgmirror.CommandTimeoutError
+	}
+
+	if err != nil && err != gogit.NoErrAlreadyUpToDate {
+		return err
+	}
+
+	if opts.Prune {
+		return b.prune(repo, origin, specs, auth)
+	}
+
+	return nil
+}
+
+// prune deletes the local refs matching the destination of the refspecs which
+// do not exist anymore on the remote.
+func (b *GoGitBackend) prune(repo *gogit.Repository, origin *gogit.Remote, specs []config.RefSpec, auth transport.AuthMethod) error {
+	remoteRefs, err := origin.List(&gogit.ListOptions{Auth: auth})
+	if err != nil {
+		return err
+	}
+
+	kept := map[plumbing.ReferenceName]bool{}
+	for _, ref := range remoteRefs {
+		for _, spec := range specs {
+			if spec.Match(ref.Name()) {
+				kept[spec.Dst(ref.Name())] = true
+			}
+		}
+	}
+
+	refs, err := repo.References()
+	if err != nil {
+		return err
+	}
+
+	stale := []plumbing.ReferenceName{}
+	refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || kept[ref.Name()] {
+			return nil
+		}
+
+		for _, spec := range specs {
+			if spec.Reverse().Match(ref.Name()) {
+				stale = append(stale, ref.Name())
+
+				break
+			}
+		}
+
+		return nil
+	})
+
+	for _, name := range stale {
+		if err := repo.Storer.RemoveReference(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Archive writes the tree of the commit, entries get the commit time and the
+// permissions git archive uses. Submodules are exported as empty folders.
+func (b *GoGitBackend) Archive(w io.Writer, dir, commit, format, prefix string) error {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+
+	c, err := repo.CommitObject(plumbing.NewHash(commit))
+	if err != nil {
+		return err
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return err
+	}
+
+	modTime := c.Committer.When
+	aw := newArchiveWriter(w, format)
+
+	if len(prefix) > 0 && strings.HasSuffix(prefix, "/") {
+		hdr := &tar.Header{Name: prefix, Typeflag: tar.TypeDir, Mode: 0775, ModTime: modTime}
+
+		if err := aw.WriteEntry(hdr, strings.NewReader("")); err != nil {
+			return err
+		}
+	}
+
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		hdr := &tar.Header{Name: prefix + name, ModTime: modTime}
+
+		switch entry.Mode {
+		case filemode.Dir, filemode.Submodule:
+			hdr.Name += "/"
+			hdr.Typeflag = tar.TypeDir
+			hdr.Mode = 0775
+
+			if err := aw.WriteEntry(hdr, strings.NewReader("")); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		blob, err := repo.BlobObject(entry.Hash)
+		if err != nil {
+			return err
+		}
+
+		r, err := blob.Reader()
+		if err != nil {
+			return err
+		}
+
+		switch entry.Mode {
+		case filemode.Symlink:
+			target := make([]byte, blob.Size)
+			_, err = io.ReadFull(r, target)
+
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = string(target)
+			hdr.Mode = 0777
+		case filemode.Executable:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Mode = 0775
+			hdr.Size = blob.Size
+		default:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Mode = 0664
+			hdr.Size = blob.Size
+		}
+
+		if err == nil {
+			err = aw.WriteEntry(hdr, r)
+		}
+
+		r.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return aw.Close()
+}
+
+func (b *GoGitBackend) RevParse(dir, ref string) (string, error) {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err == plumbing.ErrReferenceNotFound || err == plumbing.ErrObjectNotFound {
+		return "", pkgmirror.ResourceNotFoundError
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return hash.String(), nil
+}
+
+func (b *GoGitBackend) Refs(dir string) (map[string]string, error) {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := repo.References()
+	if err != nil {
+		return nil, err
+	}
+
+	refs := map[string]string{}
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference && ref.Name() != plumbing.HEAD {
+			refs[ref.Name().String()] = ref.Hash().String()
+		}
+
+		return nil
+	})
+
+	return refs, err
+}
+
+func (b *GoGitBackend) IsAncestor(dir, ancestor, commit string) bool {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return false
+	}
+
+	a, err := repo.CommitObject(plumbing.NewHash(ancestor))
+	if err != nil {
+		return false
+	}
+
+	c, err := repo.CommitObject(plumbing.NewHash(commit))
+	if err != nil {
+		return false
+	}
+
+	ok, err := a.IsAncestor(c)
+
+	return err == nil && ok
+}
+
+func (b *GoGitBackend) UpdateRefs(dir string, updates map[string]string) error {
+	if len(updates) == 0 {
+		return nil
+	}
+
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+
+	for ref, sha := range updates {
+		if err := repo.Storer.SetReference(plumbing.NewHashReference(plumbing.ReferenceName(ref), plumbing.NewHash(sha))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *GoGitBackend) RemoteUrl(dir string) (string, error) {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return "", err
+	}
+
+	origin, err := repo.Remote("origin")
+	if err != nil {
+		return "", err
+	}
+
+	if len(origin.Config().URLs) == 0 {
+		return "", pkgmirror.MissingRemoteUrlError
+	}
+
+	return origin.Config().URLs[0], nil
+}
+
+func (b *GoGitBackend) DefaultBranch(dir string) (string, error) {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return "", err
+	}
+
+	head, err := repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return "", err
+	}
+
+	if head.Type() != plumbing.SymbolicReference {
+		return "", plumbing.ErrReferenceNotFound
+	}
+
+	return head.Target().Short(), nil
+}
+
+// Submodules returns the submodules declared at the given ref with their
+// commit sorted by path, the list is empty without .gitmodules file.
+func (b *GoGitBackend) Submodules(dir, ref string) ([]*Submodule, error) {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	submodules := []*Submodule{}
+
+	file, err := tree.File(".gitmodules")
+	if err != nil {
+		return submodules, nil // no .gitmodules file
+	}
+
+	content, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+
+	modules := config.NewModules()
+	if err := modules.Unmarshal([]byte(content)); err != nil {
+		return submodules, nil // invalid .gitmodules file, like git config
+	}
+
+	for _, m := range modules.Submodules {
+		entry, err := tree.FindEntry(m.Path)
+
+		if err != nil || entry.Mode != filemode.Submodule {
+			continue
+		}
+
+		submodules = append(submodules, &Submodule{Name: m.Name, Path: m.Path, Url: m.URL, Commit: entry.Hash.String()})
+	}
+
+	sort.Slice(submodules, func(i, j int) bool {
+		return submodules[i].Path < submodules[j].Path
+	})
+
+	return submodules, nil
+}
+
+// Gc repacks the objects once the number of packs reaches the auto pack limit
+// of git gc --auto, go-git stores the fetched objects in packs.
+func (b *GoGitBackend) Gc(dir string) error {
+	files, err := ioutil.ReadDir(filepath.Join(dir, "objects", "pack"))
+	if err != nil {
+		return err
+	}
+
+	packs := 0
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".pack") {
+			packs++
+		}
+	}
+
+	if packs < gcAutoPackLimit {
+		return nil
+	}
+
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+
+	return repo.RepackObjects(&gogit.RepackConfig{})
+}
@@ -0,0 +1,194 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/rande/pkgmirror"
+	"github.com/stretchr/testify/assert"
+)
+
+const fixture = "../../fixtures/git/foo.bare"
+
+func backends() map[string]Backend {
+	config := NewGitService().Config
+
+	return map[string]Backend{
+		"binary": &BinaryBackend{Config: config},
+		"go-git": &GoGitBackend{Config: config},
+	}
+}
+
+func archiveEntries(t *testing.T, b Backend, commit, prefix string) map[string]string {
+	buf := bytes.NewBuffer([]byte(""))
+
+	assert.NoError(t, b.Archive(buf, fixture, commit, "tar", prefix))
+
+	entries := map[string]string{}
+
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		assert.NoError(t, err)
+
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		data, _ := ioutil.ReadAll(tr)
+		entries[hdr.Name] = string(data)
+	}
+
+	return entries
+}
+
+func Test_NewBackend(t *testing.T) {
+	config := NewGitService().Config
+
+	b, err := NewBackend("", config)
+	assert.NoError(t, err)
+	assert.IsType(t, &BinaryBackend{}, b)
+
+	b, err = NewBackend("go-git", config)
+	assert.NoError(t, err)
+	assert.IsType(t, &GoGitBackend{}, b)
+
+	_, err = NewBackend("foo", config)
+	assert.Error(t, err)
+}
+
+func Test_Backends_Read(t *testing.T) {
+	for name, b := range backends() {
+		commit, err := b.RevParse(fixture, "master")
+		assert.NoError(t, err, name)
+		assert.Equal(t, "9b9cc9573693611badb397b5d01a1e6645704da7", commit, name)
+
+		_, err = b.RevParse(fixture, "unknown")
+		assert.Equal(t, pkgmirror.ResourceNotFoundError, err, name)
+
+		_, err = b.RevParse(fixture, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+		assert.Equal(t, pkgmirror.ResourceNotFoundError, err, name)
+
+		refs, err := b.Refs(fixture)
+		assert.NoError(t, err, name)
+		assert.Equal(t, "9b9cc9573693611badb397b5d01a1e6645704da7", refs["refs/heads/master"], name)
+
+		tag, err := b.RevParse(fixture, "0.0.1")
+		assert.NoError(t, err, name)
+		assert.True(t, b.IsAncestor(fixture, tag, commit), name)
+	}
+}
+
+func Test_Backends_Archive(t *testing.T) {
+	expected := archiveEntries(t, backends()["binary"], "9b9cc9573693611badb397b5d01a1e6645704da7", "foo-master/")
+
+	assert.NotEmpty(t, expected)
+	assert.Equal(t, expected, archiveEntries(t, backends()["go-git"], "9b9cc9573693611badb397b5d01a1e6645704da7", "foo-master/"))
+}
+
+func Test_Backends_UpdateRefs(t *testing.T) {
+	for name, b := range backends() {
+		dir, _ := ioutil.TempDir("", "pkgmirror-backend")
+		defer os.RemoveAll(dir)
+
+		assert.NoError(t, b.Clone(context.Background(), fixture, dir, &CloneOptions{}), name)
+
+		assert.NoError(t, b.UpdateRefs(dir, map[string]string{
+			"refs/tags/copy": "9b9cc9573693611badb397b5d01a1e6645704da7",
+		}), name)
+
+		refs, err := b.Refs(dir)
+		assert.NoError(t, err, name)
+		assert.Equal(t, "9b9cc9573693611badb397b5d01a1e6645704da7", refs["refs/tags/copy"], name)
+		assert.Equal(t, "9b9cc9573693611badb397b5d01a1e6645704da7", refs["refs/heads/master"], name)
+	}
+}
+
+func Test_Backends_Remote_Without_Url(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "pkgmirror-backend")
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, exec.Command("git", "init", "--bare", dir+"/repo.git").Run())
+	assert.NoError(t, exec.Command("git", "-C", dir+"/repo.git", "config", "remote.origin.fetch", "+refs/*:refs/*").Run())
+
+	for name, b := range backends() {
+		_, err := b.RemoteUrl(dir + "/repo.git")
+		assert.Error(t, err, name)
+	}
+
+	err := backends()["go-git"].Fetch(context.Background(), dir+"/repo.git", &FetchOptions{})
+	assert.Equal(t, pkgmirror.MissingRemoteUrlError, err)
+}
+
+func Test_Backends_Repository(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "pkgmirror-backend")
+	defer os.RemoveAll(dir)
+
+	gitmodules := `[submodule "lib"]
+	path = vendor/lib
+	url = https://github.com/rande/lib.git
+[submodule "foo"]
+	path = foo
+	url = ../foo.git
+`
+
+	os.MkdirAll(dir+"/work", 0755)
+	ioutil.WriteFile(dir+"/work/.gitmodules", []byte(gitmodules), 0644)
+
+	for _, args := range [][]string{
+		{"init"},
+		{"update-index", "--add", "--cacheinfo", "160000,9b9cc9573693611badb397b5d01a1e6645704da7,vendor/lib"},
+		{"update-index", "--add", "--cacheinfo", "160000,2da62f8886014fb045e41b659e4fa83db5ef24d2,foo"},
+		{"add", ".gitmodules"},
+		{"commit", "-m", "submodules"},
+		{"clone", "--mirror", dir + "/work", dir + "/parent.git"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir + "/work"
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com", "GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+
+		output, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(output))
+	}
+
+	branch, _ := exec.Command("git", "-C", dir+"/parent.git", "symbolic-ref", "--short", "HEAD").Output()
+
+	for name, b := range backends() {
+		submodules, err := b.Submodules(dir+"/parent.git", "HEAD")
+		assert.NoError(t, err, name)
+		assert.Equal(t, []*Submodule{
+			{Name: "foo", Path: "foo", Url: "../foo.git", Commit: "2da62f8886014fb045e41b659e4fa83db5ef24d2"},
+			{Name: "lib", Path: "vendor/lib", Url: "https://github.com/rande/lib.git", Commit: "9b9cc9573693611badb397b5d01a1e6645704da7"},
+		}, submodules, name)
+
+		submodules, err = b.Submodules(fixture, "master")
+		assert.NoError(t, err, name)
+		assert.Equal(t, 0, len(submodules), name)
+
+		url, err := b.RemoteUrl(dir + "/parent.git")
+		assert.NoError(t, err, name)
+		assert.Equal(t, dir+"/work", url, name)
+
+		head, err := b.DefaultBranch(dir + "/parent.git")
+		assert.NoError(t, err, name)
+		assert.Equal(t, strings.TrimSpace(string(branch)), head, name)
+
+		assert.NoError(t, b.Gc(dir+"/parent.git"), name)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/rande/pkgmirror"
+)
+
+var (
+	URL_CREDENTIALS = regexp.MustCompile(`([\w+-]+:\/\/)[^@\/\s]+@`)
+)
+
+// Credentials used to access the remote repositories of a server. The token or
+// the username/password pair is sent as a basic authorization header over
+// http(s), the ssh key is used for ssh remotes.
+type Credentials struct {
+	Username   string
+	Password   string
+	Token      string
+	SshKey     string
+	KnownHosts string
+}
+
+// Env returns the environment variables to provide the credentials to git,
+// the values are not part of the command line so they do not leak into logs
+// or into the process list. The http configuration requires git >= 2.31.
+func (c *Credentials) Env() []string {
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+
+	if c == nil {
+		return env
+	}
+
+	username, password := c.Username, c.Password
+	if len(c.Token) > 0 {
+		password = c.Token
+
+		if len(username) == 0 {
+			username = "git"
+		}
+	}
+
+	if len(password) > 0 {
+		auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", username, password)))
+
+		env = append(env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			fmt.Sprintf("GIT_CONFIG_VALUE_0=Authorization: Basic %s", auth),
+		)
+	}
+
+	if len(c.SshKey) > 0 {
+		ssh := fmt.Sprintf("ssh -i %s -o IdentitiesOnly=yes", shellQuote(c.SshKey))
+
+		if len(c.KnownHosts) > 0 {
+			ssh += fmt.Sprintf(" -o UserKnownHostsFile=%s -o StrictHostKeyChecking=yes", shellQuote(c.KnownHosts))
+		}
+
+		env = append(env, fmt.Sprintf("GIT_SSH_COMMAND=%s", ssh))
+	}
+
+	return env
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// RedactUrl removes the credentials embedded in an url.
+func RedactUrl(url string) string {
+	return URL_CREDENTIALS.ReplaceAllString(url, "$1***@")
+}
+
+// RedactArgs removes the credentials embedded in the urls of a command line.
+func RedactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+
+	for i, arg := range args {
+		redacted[i] = RedactUrl(arg)
+	}
+
+	return redacted
+}
+
+// remoteCommand creates a git command reaching the remote server, with the
+// mirror's credentials.
+func (gs *GitService) remoteCommand(ctx context.Context, dir string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, gs.Config.Binary, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), gs.Config.Credentials.Env()...)
+
+	return cmd
+}
+
+// runRemoteCommand runs the remote command, the error is CommandTimeoutError
+// if the context deadline is exceeded.
+func (gs *GitService) runRemoteCommand(ctx context.Context, dir string, args ...string) error {
+	cmd := gs.remoteCommand(ctx, dir, args...)
+
+	gs.Logger.WithField("cmd", RedactArgs(cmd.Args)).Debug("Run command")
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return pkgmirror.CommandTimeoutError
+		}
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/boltdb/bolt"
+	"github.com/rande/pkgmirror"
+)
+
+// RepositoryInfo describes a repository available on disk, along with its
+// fetch state.
+type RepositoryInfo struct {
+	Repository
+	Upstream      string
+	Size          int64
+	DefaultBranch string
+}
+
+// InventoryFilter selects the repositories returned by Inventory, Query matches
+// a part of the path and Status is either "ok", "failing" or empty for any status.
+type InventoryFilter struct {
+	Query  string
+	Status string
+	Offset int
+	Limit  int
+}
+
+// Inventory returns the total number of repositories matching the filter and
+// the requested page, sorted by path.
+func (gs *GitService) Inventory(filter *InventoryFilter) (int, []*RepositoryInfo, error) {
+	paths := gs.findRepositories()
+
+	sort.Strings(paths)
+
+	matched := []*Repository{}
+	for _, path := range paths {
+		if !strings.Contains(path, filter.Query) {
+			continue
+		}
+
+		repo, err := gs.GetRepository(path)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if (filter.Status == "ok" && repo.Failures > 0) || (filter.Status == "failing" && repo.Failures == 0) {
+			continue
+		}
+
+		matched = append(matched, repo)
+	}
+
+	start, end := pageBounds(len(matched), filter.Offset, filter.Limit)
+
+	infos := []*RepositoryInfo{}
+	for _, repo := range matched[start:end] {
+		infos = append(infos, gs.repositoryInfo(repo))
+	}
+
+	return len(matched), infos, nil
+}
+
+// pageBounds returns the slice bounds of the page, a limit lower or equal to 0
+// means no limit.
+func pageBounds(total, offset, limit int) (int, int) {
+	if offset < 0 {
+		offset = 0
+	}
+
+	if offset > total {
+		offset = total
+	}
+
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+
+	return offset, end
+}
+
+func (gs *GitService) repositoryInfo(repo *Repository) *RepositoryInfo {
+	dir := gs.dataFolder() + string(filepath.Separator) + repo.Path
+
+	info := &RepositoryInfo{Repository: *repo}
+
+	if output, err := gs.Backend.RemoteUrl(dir); err == nil {
+		info.Upstream = RedactUrl(output)
+	}
+
+	if output, err := gs.Backend.DefaultBranch(dir); err == nil {
+		info.DefaultBranch = output
+	}
+
+	if size, err := dirSize(dir); err == nil {
+		info.Size = size
+	}
+
+	return info
+}
+
+// IsRepository returns true if the path is a repository of the data folder,
+// unlike Has the path must match a mirrored repository.
+func (gs *GitService) IsRepository(path string) bool {
+	for _, p := range gs.findRepositories() {
+		if p == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RemoveRepository deletes the repository from the disk along with its fetch
+// state and ref log, the repository will be cloned again on the next request.
+func (gs *GitService) RemoveRepository(path string) error {
+	if !gs.IsRepository(path) {
+		return pkgmirror.ResourceNotFoundError
+	}
+
+	if !gs.lockRepository(path) {
+		return pkgmirror.SyncInProgressError
+	}
+
+	defer gs.unlockRepository(path)
+
+	gs.Logger.WithFields(log.Fields{
+		"path":   path,
+		"action": "RemoveRepository",
+	}).Info("Remove repository")
+
+	if err := os.RemoveAll(gs.dataFolder() + string(filepath.Separator) + path); err != nil {
+		return err
+	}
+
+	return gs.DB.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(gs.refLogBucket()).Delete([]byte(path)); err != nil {
+			return err
+		}
+
+		return tx.Bucket(gs.Config.Code).Delete([]byte(path))
+	})
+}
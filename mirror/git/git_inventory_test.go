@@ -0,0 +1,34 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Page_Bounds(t *testing.T) {
+	cases := []struct {
+		Total, Offset, Limit int
+		Start, End           int
+	}{
+		{10, 0, 5, 0, 5},
+		{10, 5, 5, 5, 10},
+		{10, 8, 5, 8, 10},
+		{10, 20, 5, 10, 10},
+		{10, -1, 5, 0, 5},
+		{10, 2, 0, 2, 10},
+		{0, 0, 5, 0, 0},
+	}
+
+	for _, c := range cases {
+		start, end := pageBounds(c.Total, c.Offset, c.Limit)
+
+		assert.Equal(t, c.Start, start)
+		assert.Equal(t, c.End, end)
+	}
+}
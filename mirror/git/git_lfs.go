@@ -0,0 +1,135 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rande/pkgmirror"
+)
+
+var (
+	LFS_OID = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+type LfsObject struct {
+	Oid           string                `json:"oid"`
+	Size          int64                 `json:"size"`
+	Authenticated bool                  `json:"authenticated,omitempty"`
+	Actions       map[string]*LfsAction `json:"actions,omitempty"`
+	Error         *LfsError             `json:"error,omitempty"`
+}
+
+type LfsAction struct {
+	Href string `json:"href"`
+}
+
+type LfsError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type LfsBatchRequest struct {
+	Operation string       `json:"operation"`
+	Transfers []string     `json:"transfers"`
+	Objects   []*LfsObject `json:"objects"`
+}
+
+type LfsBatchResponse struct {
+	Transfer string       `json:"transfer"`
+	Objects  []*LfsObject `json:"objects"`
+}
+
+// lfsFolder returns the content addressed store shared by all repositories
+// of the server, objects are stored as objects/oid[0:2]/oid[2:4]/oid
+func (gs *GitService) lfsFolder() string {
+	return gs.Config.DataDir + string(filepath.Separator) + "lfs" + string(filepath.Separator) + gs.Config.Server
+}
+
+// LfsObjectPath returns the path of the object in the store, or an error if
+// the object is not available.
+func (gs *GitService) LfsObjectPath(oid string) (string, error) {
+	if !LFS_OID.MatchString(oid) {
+		return "", pkgmirror.ResourceNotFoundError
+	}
+
+	path := filepath.Join(gs.lfsFolder(), "objects", oid[0:2], oid[2:4], oid)
+
+	if _, err := os.Stat(path); err != nil {
+		return "", pkgmirror.ResourceNotFoundError
+	}
+
+	return path, nil
+}
+
+// LfsBatch answers a download batch request, only the basic transfer is
+// supported and objects are served from the local store.
+func (gs *GitService) LfsBatch(path string, req *LfsBatchRequest) *LfsBatchResponse {
+	res := &LfsBatchResponse{
+		Transfer: "basic",
+		Objects:  []*LfsObject{},
+	}
+
+	for _, o := range req.Objects {
+		object := &LfsObject{
+			Oid:  o.Oid,
+			Size: o.Size,
+		}
+
+		if _, err := gs.LfsObjectPath(o.Oid); err != nil {
+			object.Error = &LfsError{Code: 404, Message: "Object does not exist"}
+		} else {
+			object.Authenticated = true
+			object.Actions = map[string]*LfsAction{
+				"download": {
+					Href: fmt.Sprintf("%s/git/%s/%s/info/lfs/objects/%s", gs.Config.PublicServer, gs.Config.Server, path, o.Oid),
+				},
+			}
+		}
+
+		res.Objects = append(res.Objects, object)
+	}
+
+	return res
+}
+
+// fetchLfs downloads the lfs objects of all refs into the local store.
+func (gs *GitService) fetchLfs(path string) error {
+	logger := gs.Logger.WithFields(log.Fields{
+		"path":   path,
+		"action": "fetchLfs",
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), gs.Config.FetchTimeout)
+	defer cancel()
+
+	cmd := gs.remoteCommand(ctx, gs.dataFolder()+string(filepath.Separator)+path, "-c", fmt.Sprintf("lfs.storage=%s", gs.lfsFolder()), "lfs", "fetch", "--all", "origin")
+
+	if err := cmd.Start(); err != nil {
+		logger.WithError(err).Error("Error while starting the lfs fetch command")
+
+		return err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = pkgmirror.CommandTimeoutError
+		}
+
+		logger.WithError(err).Error("Error while waiting the lfs fetch command")
+
+		return err
+	}
+
+	logger.Debug("Complete the lfs fetch command")
+
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Lfs_Batch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pkgmirror-lfs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	gs := NewGitService()
+	gs.Config.DataDir = dir
+	gs.Config.Server = "github.com"
+	gs.Config.PublicServer = "https://mirror.example.com"
+
+	oid := "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393"
+	missing := "0000000000000000000000000000000000000000000000000000000000000000"
+
+	objectDir := filepath.Join(dir, "lfs", "github.com", "objects", "4d", "7a")
+	assert.NoError(t, os.MkdirAll(objectDir, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(objectDir, oid), []byte("Hello"), 0644))
+
+	res := gs.LfsBatch("rande/pkgmirror.git", &LfsBatchRequest{
+		Operation: "download",
+		Objects: []*LfsObject{
+			{Oid: oid, Size: 5},
+			{Oid: missing, Size: 12},
+		},
+	})
+
+	assert.Equal(t, "basic", res.Transfer)
+	assert.Equal(t, 2, len(res.Objects))
+
+	assert.Nil(t, res.Objects[0].Error)
+	assert.Equal(t, "https://mirror.example.com/git/github.com/rande/pkgmirror.git/info/lfs/objects/"+oid, res.Objects[0].Actions["download"].Href)
+
+	assert.Equal(t, 404, res.Objects[1].Error.Code)
+	assert.Nil(t, res.Objects[1].Actions)
+
+	_, err = gs.LfsObjectPath("../../etc/passwd")
+	assert.Error(t, err)
+}
@@ -0,0 +1,208 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rande/pkgmirror"
+)
+
+// Touch records a client access to the repository, the access time is saved at
+// most once per hour. A skipped unused repository is fetched on access.
+func (gs *GitService) Touch(path string) {
+	now := time.Now()
+
+	gs.lock.Lock()
+	if now.Sub(gs.accessed[path]) < time.Hour {
+		gs.lock.Unlock()
+
+		return
+	}
+	gs.accessed[path] = now
+	gs.lock.Unlock()
+
+	if !gs.Has(path) {
+		return
+	}
+
+	unused := false
+
+	err := gs.updateRepository(path, func(repo *Repository) {
+		unused = repo.Unused(now, gs.Config.UnusedAfter)
+
+		repo.LastAccessAt = now
+	})
+
+	if err != nil {
+		gs.Logger.WithFields(log.Fields{
+			"path":   path,
+			"action": "Touch",
+		}).WithError(err).Error("Unable to save the access time")
+
+		return
+	}
+
+	if unused && gs.Config.UnusedAction == "skip" {
+		gs.EnqueueFetch(path)
+	}
+}
+
+// maintainRepositories runs the scheduled gc and handles the unused repositories.
+func (gs *GitService) maintainRepositories() {
+	now := time.Now()
+
+	for _, path := range gs.findRepositories() {
+		logger := gs.Logger.WithFields(log.Fields{
+			"path":   path,
+			"action": "maintainRepositories",
+		})
+
+		repo, err := gs.GetRepository(path)
+		if err != nil {
+			logger.WithError(err).Error("Unable to load the repository state")
+
+			continue
+		}
+
+		if repo.LastAccessAt.IsZero() {
+			// no access recorded yet, start counting from now
+			gs.updateRepository(path, func(repo *Repository) {
+				repo.LastAccessAt = now
+			})
+
+			continue
+		}
+
+		if repo.Unused(now, gs.Config.UnusedAfter) && (gs.Config.UnusedAction == "archive" || gs.Config.UnusedAction == "remove") {
+			switch gs.Config.UnusedAction {
+			case "archive":
+				logger.Info("Archive unused repository")
+
+				err = gs.archiveRepository(path)
+			case "remove":
+				logger.Info("Remove unused repository")
+
+				err = gs.RemoveRepository(path)
+			}
+
+			if err != nil {
+				logger.WithError(err).Error("Unable to handle the unused repository")
+			}
+
+			continue
+		}
+
+		if gs.Config.GcInterval > 0 && now.Sub(repo.LastGcAt) >= gs.Config.GcInterval {
+			gs.StateChan <- pkgmirror.State{
+				Message: fmt.Sprintf("Gc %s", path),
+				Status:  pkgmirror.STATUS_RUNNING,
+			}
+
+			if err := gs.gc(path); err != nil {
+				logger.WithError(err).Error("Error while running gc")
+
+				continue
+			}
+
+			gs.updateRepository(path, func(repo *Repository) {
+				repo.LastGcAt = now
+			})
+		}
+	}
+}
+
+// gc packs the loose objects and removes the unreachable ones when needed.
+func (gs *GitService) gc(path string) error {
+	if !gs.lockRepository(path) {
+		return pkgmirror.SyncInProgressError
+	}
+
+	defer gs.unlockRepository(path)
+
+	return gs.Backend.Gc(gs.dataFolder() + string(filepath.Separator) + path)
+}
+
+func (gs *GitService) archiveFolder() string {
+	return fmt.Sprintf("%s/archive/%s", gs.Config.DataDir, gs.Config.Server)
+}
+
+// archiveRepository moves the repository out of the data folder, the repository
+// is not served nor fetched anymore until it is restored on the next access.
+func (gs *GitService) archiveRepository(path string) error {
+	if !gs.lockRepository(path) {
+		return pkgmirror.SyncInProgressError
+	}
+
+	defer gs.unlockRepository(path)
+
+	target := gs.archiveFolder() + string(filepath.Separator) + path
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	os.RemoveAll(target)
+
+	return os.Rename(gs.dataFolder()+string(filepath.Separator)+path, target)
+}
+
+// restoreRepository moves an archived repository back to the data folder, it
+// returns false if the repository is not archived.
+func (gs *GitService) restoreRepository(path string) (bool, error) {
+	source := gs.archiveFolder() + string(filepath.Separator) + path
+
+	if _, err := os.Stat(source); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	target := gs.dataFolder() + string(filepath.Separator) + path
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return false, err
+	}
+
+	if err := os.Rename(source, target); os.IsNotExist(err) {
+		return false, nil // restored by a concurrent request
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// restoreArchivedRepository restores the repository archived as unused on its
+// next access, whatever the clone configuration of the mirror, and fetches the
+// changes made upstream since it has been archived.
+func (gs *GitService) restoreArchivedRepository(path string) error {
+	if !validRepositoryPath(path) || gs.Has(path) {
+		return nil
+	}
+
+	logger := gs.Logger.WithFields(log.Fields{
+		"path":   path,
+		"action": "restoreArchivedRepository",
+	})
+
+	restored, err := gs.restoreRepository(path)
+	if err != nil {
+		logger.WithError(err).Error("Error while restoring the archived repository")
+
+		return err
+	}
+
+	if restored {
+		logger.Info("Archived repository restored")
+
+		gs.recordFetch(path, gs.Fetch(path))
+	}
+
+	return nil
+}
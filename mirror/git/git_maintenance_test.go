@@ -0,0 +1,93 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/boltdb/bolt"
+	"github.com/rande/goapp"
+	"github.com/rande/pkgmirror"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Restore_Archived_Repository_Without_Clone(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "pkgmirror-maintenance")
+	defer os.RemoveAll(dir)
+
+	gs := NewGitService()
+	gs.Logger = log.NewEntry(log.New())
+	gs.Config.DataDir = dir + "/data"
+	gs.Config.Server = "example.com"
+	gs.StateChan = make(chan pkgmirror.State, 10)
+
+	assert.NoError(t, gs.Init(nil))
+	defer gs.DB.Close()
+
+	assert.NoError(t, exec.Command("git", "clone", "--mirror", fixture, dir+"/data/example.com/foo.git").Run())
+	assert.NoError(t, exec.Command("git", "clone", "--mirror", fixture, dir+"/data/example.com/bar.git").Run())
+
+	assert.NoError(t, gs.archiveRepository("foo.git"))
+	assert.NoError(t, gs.archiveRepository("bar.git"))
+	assert.False(t, gs.Has("foo.git"))
+
+	// the mirror cannot clone, the repository is restored anyway
+	assert.NoError(t, gs.PrepareRepository("foo.git", "127.0.0.1"))
+	assert.True(t, gs.Has("foo.git"))
+
+	commit, err := gs.ResolveRef("bar.git", "master")
+	assert.NoError(t, err)
+	assert.Equal(t, "9b9cc9573693611badb397b5d01a1e6645704da7", commit)
+}
+
+func Test_Serve_Waits_For_Fetches(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "pkgmirror-maintenance")
+	defer os.RemoveAll(dir)
+
+	gs := NewGitService()
+	gs.Logger = log.NewEntry(log.New())
+	gs.Config.DataDir = dir + "/data"
+	gs.Config.Server = "example.com"
+	gs.Config.FetchMinInterval = time.Hour
+	gs.StateChan = make(chan pkgmirror.State, 100)
+
+	assert.NoError(t, gs.Init(nil))
+
+	assert.NoError(t, exec.Command("git", "clone", "--mirror", fixture, dir+"/data/example.com/foo.git").Run())
+
+	state := &goapp.GoroutineState{In: make(chan int), Out: make(chan int)}
+
+	done := make(chan error)
+	go func() {
+		done <- gs.Serve(state)
+	}()
+
+	gs.EnqueueFetch("foo.git")
+
+	state.In <- 1
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("Serve did not return")
+	}
+
+	// the fetches are completed and recorded before the database is closed
+	assert.Equal(t, bolt.ErrDatabaseNotOpen, gs.DB.View(func(tx *bolt.Tx) error { return nil }))
+
+	assert.NoError(t, gs.Init(nil))
+	defer gs.DB.Close()
+
+	repo, err := gs.GetRepository("foo.git")
+	assert.NoError(t, err)
+	assert.False(t, repo.LastSuccessAt.IsZero())
+}
@@ -0,0 +1,76 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// PartialRule overrides the mirrored refs and the partial clone filter of the
+// repositories matching the glob pattern.
+type PartialRule struct {
+	Pattern string
+	Refs    []string
+	Filter  string
+}
+
+// partialOptions returns the ref patterns and the partial clone filter of the
+// repository, the first matching rule wins over the mirror's options.
+func (gs *GitService) partialOptions(path string) ([]string, string) {
+	for _, rule := range gs.Config.Partial {
+		if matched, _ := filepath.Match(rule.Pattern, path); matched {
+			return rule.Refs, rule.Filter
+		}
+	}
+
+	return gs.Config.Refs, gs.Config.Filter
+}
+
+// cloneOptions returns the options of the initial clone, refs are cloned in
+// place.
+func (gs *GitService) cloneOptions(path string) *CloneOptions {
+	refs, filter := gs.partialOptions(path)
+
+	opts := &CloneOptions{Filter: filter}
+	for _, ref := range refs {
+		opts.RefSpecs = append(opts.RefSpecs, fmt.Sprintf("+%s:%s", ref, ref))
+	}
+
+	return opts
+}
+
+// fetchRefSpecs returns the refspecs of a fetch, nil to use the refspecs of
+// the remote.
+func (gs *GitService) fetchRefSpecs(path string) []string {
+	refs, _ := gs.partialOptions(path)
+
+	return refSpecs(refs, gs.Config.ImmutableTags)
+}
+
+// refSpecs maps the ref patterns to refspecs, with ImmutableTags the upstream
+// tags are fetched in a dedicated namespace and copied by preserveTags.
+func refSpecs(refs []string, immutableTags bool) []string {
+	if len(refs) == 0 {
+		if !immutableTags {
+			return nil
+		}
+
+		refs = []string{"refs/heads/*", "refs/tags/*"}
+	}
+
+	specs := []string{}
+	for _, ref := range refs {
+		if immutableTags && strings.HasPrefix(ref, "refs/tags/") {
+			specs = append(specs, fmt.Sprintf("+%s:%s%s", ref, UPSTREAM_TAGS, strings.TrimPrefix(ref, "refs/tags/")))
+		} else {
+			specs = append(specs, fmt.Sprintf("+%s:%s", ref, ref))
+		}
+	}
+
+	return specs
+}
@@ -0,0 +1,49 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RefSpecs(t *testing.T) {
+	assert.Nil(t, refSpecs(nil, false))
+
+	assert.Equal(t, []string{
+		"+refs/heads/*:refs/heads/*",
+		"+refs/tags/*:refs/pkgmirror/upstream/tags/*",
+	}, refSpecs(nil, true))
+
+	assert.Equal(t, []string{
+		"+refs/heads/master:refs/heads/master",
+		"+refs/tags/v*:refs/tags/v*",
+	}, refSpecs([]string{"refs/heads/master", "refs/tags/v*"}, false))
+
+	assert.Equal(t, []string{
+		"+refs/heads/master:refs/heads/master",
+		"+refs/tags/v*:refs/pkgmirror/upstream/tags/v*",
+	}, refSpecs([]string{"refs/heads/master", "refs/tags/v*"}, true))
+}
+
+func Test_PartialOptions(t *testing.T) {
+	gs := NewGitService()
+	gs.Config.Refs = []string{"refs/heads/master"}
+	gs.Config.Partial = []*PartialRule{
+		{Pattern: "big/*.git", Refs: []string{"refs/tags/*"}, Filter: "blob:none"},
+	}
+
+	refs, filter := gs.partialOptions("small/repo.git")
+	assert.Equal(t, []string{"refs/heads/master"}, refs)
+	assert.Equal(t, "", filter)
+
+	refs, filter = gs.partialOptions("big/repo.git")
+	assert.Equal(t, []string{"refs/tags/*"}, refs)
+	assert.Equal(t, "blob:none", filter)
+
+	assert.Equal(t, &CloneOptions{RefSpecs: []string{"+refs/tags/*:refs/tags/*"}, Filter: "blob:none"}, gs.cloneOptions("big/repo.git"))
+}
@@ -18,7 +18,7 @@ import (
 func NewGitPat(hostname string) goji.Pattern {
 	return &GitPat{
 		Hostname: hostname,
-		Pattern:  regexp.MustCompile(fmt.Sprintf(`\/git\/%s\/(.*)\/([\w\d]{40}|(.*))\.zip`, hostname)),
+		Pattern:  regexp.MustCompile(fmt.Sprintf(`\/git\/%s\/(.*)\/([\w\d]{40}|(.*))\.(zip|tar\.gz|tgz|tar)$`, hostname)),
 	}
 }
 
@@ -31,7 +31,12 @@ func (pp *GitPat) Match(ctx context.Context, r *http.Request) context.Context {
 	if results := pp.Pattern.FindStringSubmatch(r.URL.Path); len(results) == 0 {
 		return nil
 	} else {
-		return &gitPatMatch{ctx, pp.Hostname, results[1], results[2], "zip"}
+		format := results[4]
+		if format == "tgz" {
+			format = "tar.gz"
+		}
+
+		return &gitPatMatch{ctx, pp.Hostname, results[1], results[2], format}
 	}
 }
 
@@ -65,3 +70,52 @@ func (m gitPatMatch) Value(key interface{}) interface{} {
 
 	return m.Context.Value(key)
 }
+
+// NewLfsPat matches the lfs batch and object download endpoints of a repository:
+// /git/hostname/path.git/info/lfs/objects/batch and /git/hostname/path.git/info/lfs/objects/oid
+func NewLfsPat(hostname string) goji.Pattern {
+	return &LfsPat{
+		Hostname: hostname,
+		Pattern:  regexp.MustCompile(fmt.Sprintf(`\/git\/%s\/(.*\.git)\/info\/lfs\/objects\/(batch|[0-9a-f]{64})$`, hostname)),
+	}
+}
+
+type LfsPat struct {
+	Hostname string
+	Pattern  *regexp.Regexp
+}
+
+func (pp *LfsPat) Match(ctx context.Context, r *http.Request) context.Context {
+	if results := pp.Pattern.FindStringSubmatch(r.URL.Path); len(results) == 0 {
+		return nil
+	} else {
+		return &lfsPatMatch{ctx, pp.Hostname, results[1], results[2]}
+	}
+}
+
+type lfsPatMatch struct {
+	context.Context
+	Hostname string
+	Path     string
+	Object   string
+}
+
+func (m lfsPatMatch) Value(key interface{}) interface{} {
+
+	switch key {
+	case pattern.AllVariables:
+		return map[pattern.Variable]string{
+			"hostname": m.Hostname,
+			"path":     m.Path,
+			"object":   m.Object,
+		}
+	case pattern.Variable("hostname"):
+		return m.Hostname
+	case pattern.Variable("path"):
+		return m.Path
+	case pattern.Variable("object"):
+		return m.Object
+	}
+
+	return m.Context.Value(key)
+}
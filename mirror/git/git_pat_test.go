@@ -38,6 +38,32 @@ func Test_Composer_Pat_Archive(t *testing.T) {
 	assert.Equal(t, "zip", result.Value(pattern.Variable("format")))
 }
 
+func Test_Git_Pat_Archive_Formats(t *testing.T) {
+	p := NewGitPat("github.com")
+
+	formats := map[string]string{
+		"zip":    "zip",
+		"tar":    "tar",
+		"tar.gz": "tar.gz",
+		"tgz":    "tar.gz",
+	}
+
+	for ext, format := range formats {
+		c, r := mustReq("GET", "/git/github.com/kevinlebrun/colors.php/1.0.0."+ext)
+
+		result := p.Match(c, r)
+
+		assert.NotNil(t, result)
+		assert.Equal(t, "kevinlebrun/colors.php", result.Value(pattern.Variable("path")))
+		assert.Equal(t, "1.0.0", result.Value(pattern.Variable("ref")))
+		assert.Equal(t, format, result.Value(pattern.Variable("format")))
+	}
+
+	c, r := mustReq("GET", "/git/github.com/kevinlebrun/colors.php/master.rar")
+
+	assert.Nil(t, p.Match(c, r))
+}
+
 func Test_Git_Pat_AllVariables(t *testing.T) {
 	p := NewGitPat("github.com")
 
@@ -66,3 +92,26 @@ func Test_Git_Pat_OtherVariable(t *testing.T) {
 
 	assert.Nil(t, result.Value(pattern.Variable("foo")))
 }
+
+func Test_Lfs_Pat(t *testing.T) {
+	p := NewLfsPat("github.com")
+
+	c, r := mustReq("POST", "/git/github.com/rande/pkgmirror.git/info/lfs/objects/batch")
+
+	result := p.Match(c, r)
+
+	assert.NotNil(t, result)
+	assert.Equal(t, "rande/pkgmirror.git", result.Value(pattern.Variable("path")))
+	assert.Equal(t, "batch", result.Value(pattern.Variable("object")))
+
+	c, r = mustReq("GET", "/git/github.com/rande/pkgmirror.git/info/lfs/objects/4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393")
+
+	result = p.Match(c, r)
+
+	assert.NotNil(t, result)
+	assert.Equal(t, "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393", result.Value(pattern.Variable("object")))
+
+	c, r = mustReq("GET", "/git/github.com/rande/pkgmirror.git/info/refs")
+
+	assert.Nil(t, p.Match(c, r))
+}
@@ -0,0 +1,257 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/boltdb/bolt"
+	"github.com/rande/pkgmirror"
+)
+
+const (
+	UPSTREAM_TAGS  = "refs/pkgmirror/upstream/tags/"
+	PRESERVED_TAGS = "refs/pkgmirror/preserved/tags/"
+)
+
+const (
+	REF_CREATED = "created"
+	REF_UPDATED = "updated"
+	REF_FORCED  = "forced"
+	REF_DELETED = "deleted"
+)
+
+// number of ref changes kept per repository
+var refLogSize = 100
+
+// RefChange is an update of a ref detected on fetch, Alert is set for the
+// changes breaking reproducible installs: non fast-forward updates, moved,
+// deleted or preserved tags.
+type RefChange struct {
+	Ref    string
+	Before string
+	After  string
+	Type   string
+	Alert  bool
+	At     time.Time
+}
+
+func (gs *GitService) refLogBucket() []byte {
+	return []byte(fmt.Sprintf("%s-refs", gs.Config.Code))
+}
+
+func parseRefs(data []byte) map[string]string {
+	refs := map[string]string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) == 2 {
+			refs[fields[1]] = fields[0]
+		}
+	}
+
+	return refs
+}
+
+// diffRefs returns the changes between two snapshots sorted by ref, isAncestor
+// is used to detect non fast-forward updates of branches.
+func diffRefs(before, after map[string]string, isAncestor func(ancestor, commit string) bool, now time.Time) []*RefChange {
+	changes := []*RefChange{}
+
+	for ref, sha := range after {
+		change := &RefChange{Ref: ref, After: sha, At: now}
+
+		if old, ok := before[ref]; !ok {
+			change.Type = REF_CREATED
+			change.Alert = strings.HasPrefix(ref, PRESERVED_TAGS) // a tag has been preserved
+		} else if old == sha {
+			continue
+		} else {
+			change.Before = old
+			change.Type = REF_UPDATED
+
+			if isTag(ref) {
+				change.Alert = true // a tag must not move
+			} else if !isAncestor(old, sha) {
+				change.Type = REF_FORCED
+				change.Alert = true
+			}
+		}
+
+		changes = append(changes, change)
+	}
+
+	for ref, sha := range before {
+		if _, ok := after[ref]; !ok {
+			changes = append(changes, &RefChange{
+				Ref:    ref,
+				Before: sha,
+				Type:   REF_DELETED,
+				Alert:  isTag(ref),
+				At:     now,
+			})
+		}
+	}
+
+	sort.Sort(refChanges(changes))
+
+	return changes
+}
+
+// isTag returns true for the mirrored tags and the upstream tags fetched with
+// the ImmutableTags option.
+func isTag(ref string) bool {
+	return strings.HasPrefix(ref, "refs/tags/") || strings.HasPrefix(ref, UPSTREAM_TAGS)
+}
+
+type refChanges []*RefChange
+
+func (c refChanges) Len() int           { return len(c) }
+func (c refChanges) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c refChanges) Less(i, j int) bool { return c[i].Ref < c[j].Ref }
+
+func (gs *GitService) isAncestor(dir string) func(ancestor, commit string) bool {
+	return func(ancestor, commit string) bool {
+		return gs.Backend.IsAncestor(dir, ancestor, commit)
+	}
+}
+
+// recordRefChanges appends the changes to the ref log of the repository and
+// reports the alerts on the state channel.
+func (gs *GitService) recordRefChanges(path string, changes []*RefChange) error {
+	for _, change := range changes {
+		if !change.Alert {
+			continue
+		}
+
+		gs.Logger.WithFields(log.Fields{
+			"path":   path,
+			"ref":    change.Ref,
+			"before": change.Before,
+			"after":  change.After,
+			"type":   change.Type,
+			"action": "recordRefChanges",
+		}).Warn("Upstream rewrote a ref")
+
+		gs.StateChan <- pkgmirror.State{
+			Message: fmt.Sprintf("Ref %s %s on %s (%s -> %s)", change.Ref, change.Type, path, change.Before, change.After),
+			Status:  pkgmirror.STATUS_ERROR,
+		}
+	}
+
+	return gs.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(gs.refLogBucket())
+
+		entries := []*RefChange{}
+
+		if data := b.Get([]byte(path)); len(data) > 0 {
+			if err := json.Unmarshal(data, &entries); err != nil {
+				return err
+			}
+		}
+
+		entries = append(entries, changes...)
+
+		if len(entries) > refLogSize {
+			entries = entries[len(entries)-refLogSize:]
+		}
+
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(path), data)
+	})
+}
+
+// RefLog returns the ref changes recorded for the repository, oldest first.
+func (gs *GitService) RefLog(path string) ([]*RefChange, error) {
+	changes := []*RefChange{}
+
+	err := gs.DB.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(gs.refLogBucket()).Get([]byte(path))
+
+		if len(data) == 0 {
+			return nil
+		}
+
+		return json.Unmarshal(data, &changes)
+	})
+
+	return changes, err
+}
+
+// tagUpdates returns the refs to write once the upstream tags have been fetched:
+// new upstream tags are copied to refs/tags, tags moved or deleted upstream are
+// kept and their original value is recorded in the preserved namespace.
+func tagUpdates(refs map[string]string) map[string]string {
+	updates := map[string]string{}
+
+	for ref, sha := range refs {
+		if !strings.HasPrefix(ref, UPSTREAM_TAGS) {
+			continue
+		}
+
+		name := strings.TrimPrefix(ref, UPSTREAM_TAGS)
+
+		if current, ok := refs["refs/tags/"+name]; !ok {
+			updates["refs/tags/"+name] = sha
+		} else if current != sha {
+			if _, ok := refs[PRESERVED_TAGS+name]; !ok {
+				updates[PRESERVED_TAGS+name] = current
+			}
+		}
+	}
+
+	for ref, sha := range refs {
+		if !strings.HasPrefix(ref, "refs/tags/") {
+			continue
+		}
+
+		name := strings.TrimPrefix(ref, "refs/tags/")
+
+		if _, ok := refs[UPSTREAM_TAGS+name]; ok {
+			continue
+		}
+
+		if _, ok := refs[PRESERVED_TAGS+name]; !ok {
+			updates[PRESERVED_TAGS+name] = sha
+		}
+	}
+
+	return updates
+}
+
+// preserveTags applies the tag updates to the repository.
+func (gs *GitService) preserveTags(dir string) error {
+	refs, err := gs.Backend.Refs(dir)
+	if err != nil {
+		return err
+	}
+
+	updates := tagUpdates(refs)
+
+	for ref, sha := range updates {
+		if strings.HasPrefix(ref, PRESERVED_TAGS) {
+			gs.Logger.WithFields(log.Fields{
+				"dir":    dir,
+				"ref":    ref,
+				"sha":    sha,
+				"action": "preserveTags",
+			}).Warn("Preserve a tag moved or deleted upstream")
+		}
+	}
+
+	return gs.Backend.UpdateRefs(dir, updates)
+}
@@ -0,0 +1,89 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Parse_Refs(t *testing.T) {
+	refs := parseRefs([]byte("9b9cc9573693611badb397b5d01a1e6645704da7 refs/heads/master\n2da62f8886014fb045e41b659e4fa83db5ef24d2 refs/tags/0.0.1\n"))
+
+	assert.Equal(t, map[string]string{
+		"refs/heads/master": "9b9cc9573693611badb397b5d01a1e6645704da7",
+		"refs/tags/0.0.1":   "2da62f8886014fb045e41b659e4fa83db5ef24d2",
+	}, refs)
+}
+
+func Test_Diff_Refs(t *testing.T) {
+	before := map[string]string{
+		"refs/heads/master":  "a",
+		"refs/heads/develop": "b",
+		"refs/heads/old":     "c",
+		"refs/tags/1.0.0":    "d",
+		"refs/tags/1.0.1":    "e",
+		"refs/tags/1.0.2":    "f",
+	}
+
+	after := map[string]string{
+		"refs/heads/master":  "a2",
+		"refs/heads/develop": "b2",
+		"refs/heads/new":     "g",
+
+		"refs/pkgmirror/preserved/tags/1.0.2": "f",
+		"refs/tags/1.0.0":                     "d",
+		"refs/tags/1.0.1":                     "e2",
+	}
+
+	isAncestor := func(ancestor, commit string) bool {
+		return ancestor == "a" // master is fast-forwarded, develop is rewritten
+	}
+
+	now := time.Now()
+
+	changes := diffRefs(before, after, isAncestor, now)
+
+	assert.Equal(t, []*RefChange{
+		{Ref: "refs/heads/develop", Before: "b", After: "b2", Type: REF_FORCED, Alert: true, At: now},
+		{Ref: "refs/heads/master", Before: "a", After: "a2", Type: REF_UPDATED, Alert: false, At: now},
+		{Ref: "refs/heads/new", After: "g", Type: REF_CREATED, Alert: false, At: now},
+		{Ref: "refs/heads/old", Before: "c", Type: REF_DELETED, Alert: false, At: now},
+		{Ref: "refs/pkgmirror/preserved/tags/1.0.2", After: "f", Type: REF_CREATED, Alert: true, At: now},
+		{Ref: "refs/tags/1.0.1", Before: "e", After: "e2", Type: REF_UPDATED, Alert: true, At: now},
+		{Ref: "refs/tags/1.0.2", Before: "f", Type: REF_DELETED, Alert: true, At: now},
+	}, changes)
+}
+
+func Test_Tag_Updates(t *testing.T) {
+	refs := map[string]string{
+		"refs/heads/master":                   "a",
+		"refs/tags/1.0.0":                     "b",
+		"refs/tags/1.0.1":                     "c",
+		"refs/tags/1.0.2":                     "d",
+		"refs/tags/1.0.3":                     "e",
+		"refs/pkgmirror/upstream/tags/1.0.0":  "b",
+		"refs/pkgmirror/upstream/tags/1.0.1":  "c2",
+		"refs/pkgmirror/upstream/tags/1.0.3":  "e2",
+		"refs/pkgmirror/upstream/tags/1.1.0":  "f",
+		"refs/pkgmirror/preserved/tags/1.0.3": "e",
+	}
+
+	assert.Equal(t, map[string]string{
+		"refs/tags/1.1.0":                     "f", // new tag
+		"refs/pkgmirror/preserved/tags/1.0.1": "c", // moved upstream
+		"refs/pkgmirror/preserved/tags/1.0.2": "d", // deleted upstream
+	}, tagUpdates(refs))
+}
+
+func Test_Is_Tag(t *testing.T) {
+	assert.True(t, isTag("refs/tags/1.0.0"))
+	assert.True(t, isTag("refs/pkgmirror/upstream/tags/1.0.0"))
+	assert.False(t, isTag("refs/heads/master"))
+	assert.False(t, isTag("refs/pkgmirror/preserved/tags/1.0.0"))
+}
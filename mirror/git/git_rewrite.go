@@ -0,0 +1,196 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rande/pkgmirror"
+)
+
+// RewriteRule maps an url matching the pattern to a repository of the mirror,
+// the Server, Path and Ref templates are expanded with the submatches of the
+// pattern ($1, ${1} or ${name}).
+type RewriteRule struct {
+	Pattern *regexp.Regexp
+	Server  string
+	Path    string
+	Ref     string
+}
+
+// Match returns the server, path and ref of the url, ok is false if the url
+// does not match the pattern.
+func (r *RewriteRule) Match(url string) (server, path, ref string, ok bool) {
+	match := r.Pattern.FindStringSubmatchIndex(url)
+
+	if match == nil {
+		return "", "", "", false
+	}
+
+	expand := func(template string) string {
+		return string(r.Pattern.ExpandString([]byte{}, template, url, match))
+	}
+
+	return expand(r.Server), expand(r.Path), expand(r.Ref), true
+}
+
+// Rewriter rewrites the archive and repository urls of the package managers to
+// the git mirrors, rules are evaluated in order. SvnRepositories rules match the
+// svn urls of the svn mirrors.
+type Rewriter struct {
+	Archives        []*RewriteRule
+	Repositories    []*RewriteRule
+	SvnRepositories []*RewriteRule
+}
+
+// NewRewriter returns a rewriter with the default rules for GitHub, Bitbucket,
+// GitLab and git repositories.
+func NewRewriter() *Rewriter {
+	return &Rewriter{
+		Archives: []*RewriteRule{
+			{Pattern: GITHUB_ARCHIVE, Server: "$2", Path: "$3/$4", Ref: "$5"},
+			{Pattern: BITBUCKET_ARCHIVE, Server: "$2", Path: "$3/$4", Ref: "$5"},
+			{Pattern: GITLAB_ARCHIVE, Server: "$2", Path: "$3/$4", Ref: "$5"},
+		},
+		Repositories: []*RewriteRule{
+			{Pattern: GIT_REPOSITORY, Server: "$6", Path: "$8"},
+		},
+	}
+}
+
+// NewRewriterFromConfig returns a rewriter with the rules of the enabled git
+// mirrors, evaluated before the default rules. The server of a rule defaults
+// to the mirror's server. Svn rules are built from the Clone url of the svn
+// mirrors.
+func NewRewriterFromConfig(config *pkgmirror.Config) (*Rewriter, error) {
+	rewriter := NewRewriter()
+
+	codes := []string{}
+	for code := range config.Git {
+		codes = append(codes, code)
+	}
+
+	sort.Strings(codes)
+
+	archives, repositories := []*RewriteRule{}, []*RewriteRule{}
+
+	for _, code := range codes {
+		conf := config.Git[code]
+
+		if !conf.Enabled {
+			continue
+		}
+
+		if parts := strings.SplitN(conf.Clone, "{path}", 2); conf.Type == "svn" && len(parts) == 2 {
+			rewriter.SvnRepositories = append(rewriter.SvnRepositories, &RewriteRule{
+				Pattern: regexp.MustCompile(fmt.Sprintf(`^%s(.+?)%s/?$`, regexp.QuoteMeta(parts[0]), regexp.QuoteMeta(parts[1]))),
+				Server:  conf.Server,
+				Path:    "$1",
+			})
+		}
+
+		for _, r := range conf.Rewrites {
+			pattern, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid rewrite pattern for the git mirror %s: %s", code, err)
+			}
+
+			rule := &RewriteRule{Pattern: pattern, Server: r.Server, Path: r.Path, Ref: r.Ref}
+
+			if len(rule.Server) == 0 {
+				rule.Server = conf.Server
+			}
+
+			switch r.Type {
+			case "archive":
+				archives = append(archives, rule)
+			case "repository":
+				repositories = append(repositories, rule)
+			default:
+				return nil, fmt.Errorf("Invalid rewrite type for the git mirror %s: %s", code, r.Type)
+			}
+		}
+	}
+
+	rewriter.Archives = append(archives, rewriter.Archives...)
+	rewriter.Repositories = append(repositories, rewriter.Repositories...)
+
+	return rewriter, nil
+}
+
+// Archive returns the url of the archive on the mirror, or the public server if
+// no rule matches.
+func (r *Rewriter) Archive(publicServer, url string) string {
+	for _, rule := range r.Archives {
+		if server, path, ref, ok := rule.Match(url); ok {
+			return fmt.Sprintf("%s/git/%s/%s/%s.zip", publicServer, server, path, ref)
+		}
+	}
+
+	return publicServer
+}
+
+// Repository returns the url of the repository on the mirror, or the public
+// server if no rule matches. Svn repositories are only rewritten if a svn
+// mirror matches.
+func (r *Rewriter) Repository(publicServer, url string) string {
+	if server, path, ok := r.Locate(url); ok {
+		return fmt.Sprintf("%s/git/%s/%s", publicServer, server, path)
+	}
+
+	if results := SVN_REPOSITORY.FindStringSubmatch(url); len(results) > 1 {
+		return url // no svn mirror
+	}
+
+	return publicServer
+}
+
+// Locate returns the server of the mirror and the path of the repository,
+// ie: github.com and rande/pkgmirror.git. ok is false if no rule matches.
+func (r *Rewriter) Locate(url string) (server, path string, ok bool) {
+	for _, rule := range r.SvnRepositories {
+		if server, path, _, ok := rule.Match(url); ok {
+			return server, path + ".git", true
+		}
+	}
+
+	if results := SVN_REPOSITORY.FindStringSubmatch(url); len(results) > 1 {
+		return "", "", false
+	}
+
+	for _, rule := range r.Repositories {
+		if server, path, _, ok := rule.Match(url); ok {
+			return server, path + ".git", true
+		}
+	}
+
+	return "", "", false
+}
+
+// Svn returns the url of the repository on the svn mirror, the git ref matching
+// the composer svn reference and the url of its archive. ok is false if no svn
+// mirror matches the url or if the reference is not supported.
+func (r *Rewriter) Svn(publicServer, url, reference string) (repository, ref, archive string, ok bool) {
+	for _, rule := range r.SvnRepositories {
+		server, path, _, matched := rule.Match(url)
+
+		if !matched {
+			continue
+		}
+
+		gitRef, err := SvnReference(reference)
+		if err != nil {
+			return "", "", "", false
+		}
+
+		return fmt.Sprintf("%s/git/%s/%s.git", publicServer, server, path), gitRef, fmt.Sprintf("%s/git/%s/%s/%s.zip", publicServer, server, path, gitRef), true
+	}
+
+	return "", "", "", false
+}
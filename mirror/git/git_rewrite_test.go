@@ -0,0 +1,94 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"testing"
+
+	"github.com/rande/pkgmirror"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Rewriter_From_Config(t *testing.T) {
+	publicServer := "https://mirrors.localhost"
+
+	config := &pkgmirror.Config{
+		Git: map[string]*pkgmirror.GitConfig{
+			"gitea": {
+				Server:  "gitea.example.com",
+				Enabled: true,
+				Rewrites: []*pkgmirror.GitRewriteRule{
+					{Type: "archive", Pattern: `^https://gitea\.example\.com/(?P<path>.+)/archive/(?P<ref>[\w\.-]+)\.zip$`, Path: "${path}", Ref: "${ref}"},
+				},
+			},
+			"gitlab": {
+				Server:  "gitlab.example.com",
+				Enabled: true,
+				Rewrites: []*pkgmirror.GitRewriteRule{
+					{Type: "archive", Pattern: `^https://gitlab\.example\.com/(.+)/-/archive/([\w\.-]+)/[^/]+\.zip$`, Path: "$1", Ref: "$2"},
+					{Type: "repository", Pattern: `^ssh://git@gitlab\.example\.com:2222/(.+)\.git$`, Path: "$1"},
+				},
+			},
+			"disabled": {
+				Server:  "disabled.example.com",
+				Enabled: false,
+				Rewrites: []*pkgmirror.GitRewriteRule{
+					{Type: "repository", Pattern: `.*`, Path: "foo"},
+				},
+			},
+		},
+	}
+
+	rewriter, err := NewRewriterFromConfig(config)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "https://mirrors.localhost/git/gitea.example.com/org/repo/1.0.0.zip", rewriter.Archive(publicServer, "https://gitea.example.com/org/repo/archive/1.0.0.zip"))
+	assert.Equal(t, "https://mirrors.localhost/git/gitlab.example.com/group/sub/repo/1.0.0.zip", rewriter.Archive(publicServer, "https://gitlab.example.com/group/sub/repo/-/archive/1.0.0/repo-1.0.0.zip"))
+	assert.Equal(t, "https://mirrors.localhost/git/gitlab.example.com/group/sub/repo.git", rewriter.Repository(publicServer, "ssh://git@gitlab.example.com:2222/group/sub/repo.git"))
+
+	// default rules
+	assert.Equal(t, "https://mirrors.localhost/git/github.com/sonata-project/exporter/b9098b5007c525a238ddf44d578b8efae7bccc72.zip", rewriter.Archive(publicServer, "https://api.github.com/repos/sonata-project/exporter/zipball/b9098b5007c525a238ddf44d578b8efae7bccc72"))
+	assert.Equal(t, "https://mirrors.localhost/git/github.com/sonata-project/exporter.git", rewriter.Repository(publicServer, "https://github.com/sonata-project/exporter.git"))
+}
+
+func Test_Rewriter_Invalid_Config(t *testing.T) {
+	config := &pkgmirror.Config{
+		Git: map[string]*pkgmirror.GitConfig{
+			"github": {
+				Server:  "github.com",
+				Enabled: true,
+				Rewrites: []*pkgmirror.GitRewriteRule{
+					{Type: "archive", Pattern: `(`},
+				},
+			},
+		},
+	}
+
+	_, err := NewRewriterFromConfig(config)
+	assert.Error(t, err)
+
+	config.Git["github"].Rewrites[0] = &pkgmirror.GitRewriteRule{Type: "foo", Pattern: `.*`}
+
+	_, err = NewRewriterFromConfig(config)
+	assert.Error(t, err)
+}
+
+func Test_Rewriter_Locate(t *testing.T) {
+	rewriter := NewRewriter()
+
+	server, path, ok := rewriter.Locate("https://github.com/rande/pkgmirror.git")
+	assert.True(t, ok)
+	assert.Equal(t, "github.com", server)
+	assert.Equal(t, "rande/pkgmirror.git", path)
+
+	server, path, ok = rewriter.Locate("git@github.com:rande/pkgmirror")
+	assert.True(t, ok)
+	assert.Equal(t, "github.com", server)
+	assert.Equal(t, "rande/pkgmirror.git", path)
+
+	_, _, ok = rewriter.Locate("ftp://example.com/foo.git")
+	assert.False(t, ok)
+}
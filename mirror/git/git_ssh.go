@@ -0,0 +1,306 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rande/pkgmirror"
+	"golang.org/x/crypto/ssh"
+)
+
+// SshServer serves the mirrored repositories over ssh, clients can only fetch
+// with git-upload-pack. The repository path is prefixed by the server of the
+// mirror, ie: ssh://git@mirror.example.com:2222/github.com/rande/pkgmirror.git
+type SshServer struct {
+	Config *ssh.ServerConfig
+	Logger *log.Entry
+	Mirror func(server string) *GitService // returns the enabled mirror of a server, or nil
+}
+
+// NewSshServerConfig returns the ssh configuration accepting the clients with
+// a public key listed in the authorized keys file. Invalid lines are logged and
+// skipped, an error is returned if the file contains no valid key.
+func NewSshServerConfig(hostKeyFile, authorizedKeysFile string, logger *log.Entry) (*ssh.ServerConfig, error) {
+	data, err := ioutil.ReadFile(authorizedKeysFile)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]bool{}
+
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"file": authorizedKeysFile,
+				"line": i + 1,
+			}).WithError(err).Warn("Skipping invalid authorized key")
+
+			continue
+		}
+
+		keys[string(key.Marshal())] = true
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("No valid key in the authorized keys file: %s", authorizedKeysFile)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if keys[string(key.Marshal())] {
+				return &ssh.Permissions{}, nil
+			}
+
+			return nil, fmt.Errorf("Unknown public key for %s", conn.User())
+		},
+	}
+
+	data, err = ioutil.ReadFile(hostKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	config.AddHostKey(signer)
+
+	return config, nil
+}
+
+// parseSshCommand returns the git command, the mirror's server and the
+// repository path of the command sent by the client, ie:
+// git-upload-pack '/github.com/foo/bar.git'. The .git suffix is added if
+// missing, so the repository is the one served by the http endpoint.
+func parseSshCommand(command string) (string, string, string, error) {
+	parts := strings.SplitN(strings.TrimSpace(command), " ", 2)
+
+	if len(parts) != 2 {
+		return "", "", "", pkgmirror.ResourceNotFoundError
+	}
+
+	path := strings.Trim(strings.TrimSpace(parts[1]), `'"`)
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "/"), "git/") // same path as the http endpoint
+
+	segments := strings.SplitN(strings.TrimSuffix(path, "/"), "/", 2)
+	if len(segments) != 2 || len(strings.TrimSuffix(segments[1], ".git")) == 0 {
+		return "", "", "", pkgmirror.ResourceNotFoundError
+	}
+
+	if !strings.HasSuffix(segments[1], ".git") {
+		segments[1] += ".git"
+	}
+
+	for _, segment := range strings.Split(path, "/") {
+		if segment == ".." {
+			return "", "", "", pkgmirror.ResourceNotFoundError
+		}
+	}
+
+	return parts[0], segments[0], segments[1], nil
+}
+
+// Serve accepts the connections until the listener is closed, the open
+// connections are then closed so the running commands stop.
+func (s *SshServer) Serve(l net.Listener) error {
+	lock := sync.Mutex{}
+	conns := map[net.Conn]bool{}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			lock.Lock()
+			for c := range conns {
+				c.Close()
+			}
+			lock.Unlock()
+
+			return err
+		}
+
+		lock.Lock()
+		conns[conn] = true
+		lock.Unlock()
+
+		go func() {
+			s.handleConn(conn)
+
+			lock.Lock()
+			delete(conns, conn)
+			lock.Unlock()
+		}()
+	}
+}
+
+func (s *SshServer) handleConn(nConn net.Conn) {
+	conn, chans, reqs, err := ssh.NewServerConn(nConn, s.Config)
+	if err != nil {
+		s.Logger.WithField("client", nConn.RemoteAddr().String()).WithError(err).Info("Ssh handshake failed")
+
+		nConn.Close()
+
+		return
+	}
+
+	defer conn.Close()
+
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go s.handleSession(conn, channel, requests)
+	}
+}
+
+func (s *SshServer) handleSession(conn *ssh.ServerConn, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	env := []string{}
+
+	for req := range requests {
+		switch req.Type {
+		case "env":
+			payload := struct{ Name, Value string }{}
+
+			// the protocol version is negotiated with the env
+			if err := ssh.Unmarshal(req.Payload, &payload); err == nil && payload.Name == "GIT_PROTOCOL" {
+				env = append(env, fmt.Sprintf("GIT_PROTOCOL=%s", payload.Value))
+			}
+
+			req.Reply(true, nil)
+
+		case "exec":
+			payload := struct{ Command string }{}
+
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+
+				continue
+			}
+
+			req.Reply(true, nil)
+
+			status := s.exec(conn, channel, payload.Command, env)
+
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+
+			return
+
+		default:
+			req.Reply(false, nil) // no shell nor pty
+		}
+	}
+}
+
+// exec runs git-upload-pack on the repository, the repository is cloned on
+// the first access like with the http endpoint. It returns the exit status.
+func (s *SshServer) exec(conn *ssh.ServerConn, channel ssh.Channel, command string, env []string) uint32 {
+	logger := s.Logger.WithFields(log.Fields{
+		"client":  conn.RemoteAddr().String(),
+		"command": command,
+	})
+
+	name, server, path, err := parseSshCommand(command)
+
+	if err == nil && name == "git-receive-pack" {
+		logger.Warn("Push rejected")
+
+		fmt.Fprintln(channel.Stderr(), "The mirror is read-only, push is not allowed")
+
+		return 1
+	}
+
+	var gs *GitService
+	if err == nil && name == "git-upload-pack" {
+		gs = s.Mirror(server)
+	}
+
+	if gs == nil {
+		logger.Info("Invalid command")
+
+		fmt.Fprintln(channel.Stderr(), "Invalid command or repository")
+
+		return 1
+	}
+
+	client := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+
+	if err := gs.PrepareRepository(path, client); err != nil {
+		fmt.Fprintln(channel.Stderr(), err.Error())
+
+		return 1
+	}
+
+	if !gs.Has(path) {
+		fmt.Fprintln(channel.Stderr(), pkgmirror.ResourceNotFoundError.Error())
+
+		return 1
+	}
+
+	logger.Debug("Run git-upload-pack")
+
+	cmd := exec.Command(gs.Config.Binary, "upload-pack", gs.dataFolder()+string(filepath.Separator)+path)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = channel
+	cmd.Stderr = channel.Stderr()
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return 1
+	}
+
+	if err := cmd.Start(); err != nil {
+		logger.WithError(err).Error("Error while starting git-upload-pack")
+
+		return 1
+	}
+
+	go func() {
+		io.Copy(stdin, channel)
+		stdin.Close()
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		logger.WithError(err).Info("Error while running git-upload-pack")
+
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,171 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rande/pkgmirror"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_ParseSshCommand(t *testing.T) {
+	cases := []struct {
+		command, name, server, path string
+	}{
+		{"git-upload-pack '/github.com/rande/pkgmirror.git'", "git-upload-pack", "github.com", "rande/pkgmirror.git"},
+		{"git-upload-pack 'github.com/rande/pkgmirror.git'", "git-upload-pack", "github.com", "rande/pkgmirror.git"},
+		{"git-upload-pack '/git/github.com/rande/pkgmirror.git'", "git-upload-pack", "github.com", "rande/pkgmirror.git"},
+		{"git-receive-pack 'github.com/rande/pkgmirror.git'", "git-receive-pack", "github.com", "rande/pkgmirror.git"},
+		{"git-upload-pack 'git/github.com/rande/pkgmirror'", "git-upload-pack", "github.com", "rande/pkgmirror.git"},
+		{"git-upload-pack '/github.com/rande/pkgmirror/'", "git-upload-pack", "github.com", "rande/pkgmirror.git"},
+	}
+
+	for _, c := range cases {
+		name, server, path, err := parseSshCommand(c.command)
+
+		assert.NoError(t, err, c.command)
+		assert.Equal(t, c.name, name, c.command)
+		assert.Equal(t, c.server, server, c.command)
+		assert.Equal(t, c.path, path, c.command)
+	}
+
+	for _, command := range []string{"", "git-upload-pack", "git-upload-pack 'github.com'", "git-upload-pack 'github.com/../../etc/passwd'", "git-upload-pack 'github.com/.git'"} {
+		_, _, _, err := parseSshCommand(command)
+
+		assert.Error(t, err, command)
+	}
+}
+
+func newSshKey(t *testing.T) (*rsa.PrivateKey, ssh.Signer) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	signer, err := ssh.NewSignerFromKey(key)
+	assert.NoError(t, err)
+
+	return key, signer
+}
+
+func Test_SshServer(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "pkgmirror-ssh")
+	defer os.RemoveAll(dir)
+
+	hostKey, _ := newSshKey(t)
+	_, clientSigner := newSshKey(t)
+	_, unknownSigner := newSshKey(t)
+
+	ioutil.WriteFile(dir+"/host_key", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(hostKey)}), 0600)
+	ioutil.WriteFile(dir+"/no_keys", []byte("# no key\ninvalid-type AAAA\n"), 0600)
+
+	_, err := NewSshServerConfig(dir+"/host_key", dir+"/no_keys", log.NewEntry(log.New()))
+	assert.Error(t, err)
+
+	// the valid key after an invalid line is loaded
+	authorizedKeys := append([]byte("# clients\ninvalid-type AAAA\n\n"), ssh.MarshalAuthorizedKey(clientSigner.PublicKey())...)
+	ioutil.WriteFile(dir+"/authorized_keys", authorizedKeys, 0600)
+
+	config, err := NewSshServerConfig(dir+"/host_key", dir+"/authorized_keys", log.NewEntry(log.New()))
+	assert.NoError(t, err)
+
+	gs := NewGitService()
+	gs.Logger = log.NewEntry(log.New())
+	gs.Config.DataDir = dir + "/data"
+	gs.Config.Server = "example.com"
+	gs.StateChan = make(chan pkgmirror.State, 10)
+
+	assert.NoError(t, gs.Init(nil))
+	defer gs.DB.Close()
+
+	assert.NoError(t, exec.Command("git", "clone", "--mirror", fixture, dir+"/data/example.com/foo.git").Run())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	server := &SshServer{
+		Config: config,
+		Logger: gs.Logger,
+		Mirror: func(server string) *GitService {
+			if server == "example.com" {
+				return gs
+			}
+
+			return nil
+		},
+	}
+
+	served := make(chan error, 1)
+
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	dial := func(signer ssh.Signer) (*ssh.Client, error) {
+		return ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+			User:            "git",
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+	}
+
+	_, err = dial(unknownSigner)
+	assert.Error(t, err)
+
+	client, err := dial(clientSigner)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	run := func(command string) (string, error) {
+		session, err := client.NewSession()
+		assert.NoError(t, err)
+		defer session.Close()
+
+		session.Stdin = strings.NewReader("0000") // end of the negotiation
+
+		output, err := session.Output(command)
+
+		return string(output), err
+	}
+
+	output, err := run("git-upload-pack '/example.com/foo.git'")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "9b9cc9573693611badb397b5d01a1e6645704da7 refs/heads/master")
+
+	_, err = run("git-receive-pack '/example.com/foo.git'")
+	assert.IsType(t, &ssh.ExitError{}, err)
+
+	_, err = run("git-upload-pack '/unknown.com/foo.git'")
+	assert.IsType(t, &ssh.ExitError{}, err)
+
+	// closing the listener stops the server and closes the open connections
+	listener.Close()
+
+	assert.Error(t, <-served)
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- client.Wait()
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "the client connection is not closed")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"time"
+)
+
+// Repository stores the fetch state of a mirrored repository, the Path is
+// relative to the server's data folder (ie, rande/pkgmirror.git).
+type Repository struct {
+	Path          string
+	LastFetchAt   time.Time
+	LastSuccessAt time.Time
+	LastFailureAt time.Time
+	LastError     string
+	Failures      int
+	RetryAt       time.Time
+	LastAccessAt  time.Time
+	LastGcAt      time.Time
+	LastChangeAt  time.Time
+	FetchInterval time.Duration
+	NextFetchAt   time.Time
+}
+
+// CanFetch returns false while a failing repository is waiting for its backoff delay.
+func (r *Repository) CanFetch(now time.Time) bool {
+	return r.Failures == 0 || !now.Before(r.RetryAt)
+}
+
+// Fail records a failed fetch, the retry delay doubles on each consecutive
+// failure starting from base and is capped to max.
+func (r *Repository) Fail(err error, now time.Time, base, max time.Duration) {
+	r.LastFetchAt = now
+	r.LastFailureAt = now
+	r.LastError = err.Error()
+	r.Failures++
+
+	delay := base
+	for i := 1; i < r.Failures && delay < max; i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		delay = max
+	}
+
+	r.RetryAt = now.Add(delay)
+}
+
+// Due returns true once the next scheduled fetch is reached.
+func (r *Repository) Due(now time.Time) bool {
+	return !now.Before(r.NextFetchAt)
+}
+
+// Schedule computes the next fetch after a successful fetch, it must be called
+// before Succeed. The interval is reset to min when the refs changed or when a
+// client accessed the repository since the previous fetch, and doubles up to
+// max otherwise.
+func (r *Repository) Schedule(now time.Time, min, max time.Duration) {
+	if r.LastChangeAt.After(r.LastFetchAt) || r.LastAccessAt.After(r.LastFetchAt) || r.FetchInterval < min {
+		r.FetchInterval = min
+	} else {
+		r.FetchInterval *= 2
+	}
+
+	if r.FetchInterval > max {
+		r.FetchInterval = max
+	}
+
+	r.NextFetchAt = now.Add(r.FetchInterval)
+}
+
+func (r *Repository) Succeed(now time.Time) {
+	r.LastFetchAt = now
+	r.LastSuccessAt = now
+	r.LastError = ""
+	r.Failures = 0
+	r.RetryAt = time.Time{}
+}
+
+// Unused returns true if no client accessed the repository for the given period,
+// a period of 0 disables the check.
+func (r *Repository) Unused(now time.Time, after time.Duration) bool {
+	return after > 0 && !r.LastAccessAt.IsZero() && now.Sub(r.LastAccessAt) > after
+}
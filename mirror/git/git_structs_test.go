@@ -0,0 +1,106 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Repository_Backoff(t *testing.T) {
+	now := time.Now()
+	r := &Repository{Path: "foo.git"}
+
+	assert.True(t, r.CanFetch(now))
+
+	r.Fail(errors.New("exit status 128"), now, time.Minute, 10*time.Minute)
+
+	assert.Equal(t, 1, r.Failures)
+	assert.Equal(t, "exit status 128", r.LastError)
+	assert.Equal(t, now.Add(time.Minute), r.RetryAt)
+	assert.False(t, r.CanFetch(now))
+	assert.True(t, r.CanFetch(now.Add(time.Minute)))
+
+	r.Fail(errors.New("exit status 128"), now, time.Minute, 10*time.Minute)
+	assert.Equal(t, now.Add(2*time.Minute), r.RetryAt)
+
+	r.Fail(errors.New("exit status 128"), now, time.Minute, 10*time.Minute)
+	assert.Equal(t, now.Add(4*time.Minute), r.RetryAt)
+
+	for i := 0; i < 10; i++ {
+		r.Fail(errors.New("exit status 128"), now, time.Minute, 10*time.Minute)
+	}
+	assert.Equal(t, now.Add(10*time.Minute), r.RetryAt)
+
+	r.Succeed(now)
+
+	assert.Equal(t, 0, r.Failures)
+	assert.Equal(t, "", r.LastError)
+	assert.True(t, r.CanFetch(now))
+}
+
+func Test_Repository_Unused(t *testing.T) {
+	now := time.Now()
+
+	r := &Repository{Path: "rande/pkgmirror.git"}
+
+	assert.False(t, r.Unused(now, 24*time.Hour)) // no access recorded
+
+	r.LastAccessAt = now.Add(-48 * time.Hour)
+
+	assert.True(t, r.Unused(now, 24*time.Hour))
+	assert.False(t, r.Unused(now, 72*time.Hour))
+	assert.False(t, r.Unused(now, 0))
+}
+
+func Test_Repository_Schedule(t *testing.T) {
+	now := time.Now()
+
+	r := &Repository{Path: "rande/pkgmirror.git"}
+
+	assert.True(t, r.Due(now))
+
+	r.Schedule(now, time.Minute, 10*time.Minute)
+	r.Succeed(now)
+
+	assert.Equal(t, time.Minute, r.FetchInterval)
+	assert.False(t, r.Due(now))
+	assert.True(t, r.Due(now.Add(time.Minute)))
+
+	// no change, the interval doubles up to max
+	for _, expected := range []time.Duration{2, 4, 8, 10, 10} {
+		now = r.NextFetchAt
+		r.Schedule(now, time.Minute, 10*time.Minute)
+		r.Succeed(now)
+
+		assert.Equal(t, expected*time.Minute, r.FetchInterval)
+	}
+
+	// the refs changed on the last fetch
+	r.LastChangeAt = now.Add(time.Second)
+	now = r.NextFetchAt
+	r.Schedule(now, time.Minute, 10*time.Minute)
+	r.Succeed(now)
+
+	assert.Equal(t, time.Minute, r.FetchInterval)
+
+	// a client accessed the repository
+	now = r.NextFetchAt
+	r.Schedule(now, time.Minute, 10*time.Minute)
+	r.Succeed(now)
+	assert.Equal(t, 2*time.Minute, r.FetchInterval)
+
+	r.LastAccessAt = now.Add(time.Second)
+	now = r.NextFetchAt
+	r.Schedule(now, time.Minute, 10*time.Minute)
+	r.Succeed(now)
+
+	assert.Equal(t, time.Minute, r.FetchInterval)
+	assert.Equal(t, now.Add(time.Minute), r.NextFetchAt)
+}
@@ -0,0 +1,351 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+type Submodule struct {
+	Name   string
+	Path   string
+	Url    string
+	Commit string
+}
+
+// parseSubmodules parses the output of git config --get-regexp on a .gitmodules file.
+func parseSubmodules(output []byte) map[string]*Submodule {
+	submodules := map[string]*Submodule{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 2)
+
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], "submodule.") {
+			continue
+		}
+
+		key := fields[0][len("submodule."):]
+		i := strings.LastIndex(key, ".")
+
+		if i < 0 {
+			continue
+		}
+
+		name := key[:i]
+		if _, ok := submodules[name]; !ok {
+			submodules[name] = &Submodule{Name: name}
+		}
+
+		switch key[i+1:] {
+		case "path":
+			submodules[name].Path = fields[1]
+		case "url":
+			submodules[name].Url = fields[1]
+		}
+	}
+
+	return submodules
+}
+
+// SubmoduleRepository returns the server and the repository path of a submodule
+// url, relative urls are resolved against the parent repository. Paths outside
+// of the mirror are rejected, and the path always ends with .git like the
+// repositories fetched by the sync.
+func SubmoduleRepository(server, parent, url string) (string, string, bool) {
+	if strings.HasPrefix(url, "./") || strings.HasPrefix(url, "../") {
+		if p := strings.TrimSuffix(path.Join(parent, url), ".git"); validRepositoryPath(p) {
+			return server, p + ".git", true
+		}
+
+		return "", "", false
+	}
+
+	if results := GIT_REPOSITORY.FindStringSubmatch(url); len(results) > 1 && validRepositoryPath(results[8]) {
+		return results[6], results[8] + ".git", true
+	}
+
+	return "", "", false
+}
+
+// validRepositoryPath returns false if the path is absolute, is a folder or
+// contains a .. segment, so the repository cannot be written outside of the
+// data folder.
+func validRepositoryPath(p string) bool {
+	if len(p) == 0 || strings.HasPrefix(p, "/") || strings.HasSuffix(p, "/") {
+		return false
+	}
+
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Submodules returns the submodules declared at the given ref with their commit.
+func (gs *GitService) Submodules(path, ref string) ([]*Submodule, error) {
+	return gs.Backend.Submodules(gs.dataFolder()+string(filepath.Separator)+path, ref)
+}
+
+// submoduleMirror returns the service mirroring the submodule with the repository path, if any.
+func (gs *GitService) submoduleMirror(path string, s *Submodule) (*GitService, string) {
+	server, repository, ok := SubmoduleRepository(gs.Config.Server, path, s.Url)
+
+	if !ok || gs.Mirrors == nil {
+		return nil, ""
+	}
+
+	if mirror := gs.Mirrors(server); mirror != nil {
+		return mirror, repository
+	}
+
+	return nil, ""
+}
+
+// mirrorSubmodules clones the submodules of the repository's HEAD into their
+// matching mirror. Clones run one after the other in the background, a
+// submodule mirror will mirror its own submodules once cloned.
+func (gs *GitService) mirrorSubmodules(path string) {
+	logger := gs.Logger.WithFields(log.Fields{
+		"path":   path,
+		"action": "mirrorSubmodules",
+	})
+
+	submodules, err := gs.Submodules(path, "HEAD")
+	if err != nil {
+		logger.WithError(err).Error("Unable to list submodules")
+
+		return
+	}
+
+	type clone struct {
+		mirror     *GitService
+		repository string
+	}
+
+	clones := []clone{}
+
+	for _, s := range submodules {
+		mirror, repository := gs.submoduleMirror(path, s)
+
+		if mirror == nil || len(mirror.Config.Clone) == 0 {
+			logger.WithField("url", s.Url).Debug("No mirror available for the submodule")
+
+			continue
+		}
+
+		logger.WithFields(log.Fields{
+			"url":        s.Url,
+			"server":     mirror.Config.Server,
+			"repository": repository,
+		}).Info("Mirror submodule")
+
+		clones = append(clones, clone{mirror, repository})
+	}
+
+	if len(clones) == 0 {
+		return
+	}
+
+	go func() {
+		for _, c := range clones {
+			c.mirror.EnsureRepository(c.repository)
+		}
+	}()
+}
+
+// archiveWriter receives the entries of a tar stream and encodes them into
+// the requested format.
+type archiveWriter interface {
+	WriteEntry(hdr *tar.Header, r io.Reader) error
+	Close() error
+}
+
+func newArchiveWriter(w io.Writer, format string) archiveWriter {
+	switch format {
+	case "zip":
+		return &zipArchiveWriter{zw: zip.NewWriter(w)}
+	case "tar.gz":
+		gw := gzip.NewWriter(w)
+
+		return &tarArchiveWriter{tw: tar.NewWriter(gw), gw: gw}
+	default:
+		return &tarArchiveWriter{tw: tar.NewWriter(w)}
+	}
+}
+
+type tarArchiveWriter struct {
+	tw *tar.Writer
+	gw *gzip.Writer
+}
+
+func (a *tarArchiveWriter) WriteEntry(hdr *tar.Header, r io.Reader) error {
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(a.tw, r)
+
+	return err
+}
+
+func (a *tarArchiveWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+
+	if a.gw != nil {
+		return a.gw.Close()
+	}
+
+	return nil
+}
+
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchiveWriter) WriteEntry(hdr *tar.Header, r io.Reader) error {
+	fh, err := zip.FileInfoHeader(hdr.FileInfo())
+	if err != nil {
+		return err
+	}
+
+	fh.Name = hdr.Name
+
+	if hdr.Typeflag == tar.TypeDir {
+		_, err := a.zw.CreateHeader(fh)
+
+		return err
+	}
+
+	fh.Method = zip.Deflate
+
+	fw, err := a.zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+
+	if hdr.Typeflag == tar.TypeSymlink {
+		_, err = fw.Write([]byte(hdr.Linkname))
+	} else {
+		_, err = io.Copy(fw, r)
+	}
+
+	return err
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.zw.Close()
+}
+
+// writeArchiveWithSubmodules generates the archive of the repository and
+// includes the content of the mirrored submodules.
+func (gs *GitService) writeArchiveWithSubmodules(w io.Writer, path, ref, commit, format string) error {
+	aw := newArchiveWriter(w, format)
+
+	prefix := ""
+	if gs.Config.ArchivePrefix {
+		prefix = archivePrefix(path, ref)
+	}
+
+	if err := gs.writeTarEntries(aw, path, commit, prefix, false); err != nil {
+		return err
+	}
+
+	return aw.Close()
+}
+
+// writeTarEntries copies the entries of the repository at the given ref into
+// the archive writer, submodule entries skip their root folder as it is already
+// part of the parent archive.
+func (gs *GitService) writeTarEntries(aw archiveWriter, path, ref, prefix string, submodule bool) error {
+	logger := gs.Logger.WithFields(log.Fields{
+		"path":   path,
+		"ref":    ref,
+		"prefix": prefix,
+		"action": "writeTarEntries",
+	})
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(gs.Backend.Archive(pw, gs.dataFolder()+string(filepath.Separator)+path, ref, "tar", prefix))
+	}()
+
+	defer pr.Close() // stop the archive on error
+
+	tr := tar.NewReader(pr)
+
+	for {
+		hdr, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			logger.WithError(err).Error("Error while reading the archive")
+
+			return err
+		}
+
+		if hdr.Typeflag == tar.TypeXGlobalHeader || (submodule && hdr.Name == prefix) {
+			continue
+		}
+
+		if err := aw.WriteEntry(hdr, tr); err != nil {
+			return err
+		}
+	}
+
+	submodules, err := gs.Submodules(path, ref)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range submodules {
+		mirror, repository := gs.submoduleMirror(path, s)
+
+		if mirror == nil || !mirror.Has(repository) {
+			logger.WithField("url", s.Url).Warn("Submodule not mirrored, skipping its content")
+
+			continue
+		}
+
+		// a stale mirror does not fail the parent archive, the submodule is
+		// fetched so the commit is available for the next archives
+		if _, err := mirror.Backend.RevParse(mirror.dataFolder()+string(filepath.Separator)+repository, s.Commit); err != nil {
+			logger.WithFields(log.Fields{
+				"url":    s.Url,
+				"commit": s.Commit,
+			}).WithError(err).Warn("Submodule commit not mirrored, skipping its content")
+
+			mirror.EnqueueFetch(repository)
+
+			continue
+		}
+
+		if err := mirror.writeTarEntries(aw, repository, s.Commit, prefix+s.Path+"/", true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
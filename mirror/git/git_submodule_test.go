@@ -0,0 +1,212 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rande/pkgmirror"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Parse_Submodules(t *testing.T) {
+	output := []byte(`submodule.vendor/foo.path vendor/foo
+submodule.vendor/foo.url https://github.com/rande/foo.git
+submodule.bar.path libs/bar
+submodule.bar.url ../bar.git
+submodule.bar.branch master
+`)
+
+	submodules := parseSubmodules(output)
+
+	assert.Equal(t, 2, len(submodules))
+	assert.Equal(t, "vendor/foo", submodules["vendor/foo"].Path)
+	assert.Equal(t, "https://github.com/rande/foo.git", submodules["vendor/foo"].Url)
+	assert.Equal(t, "libs/bar", submodules["bar"].Path)
+	assert.Equal(t, "../bar.git", submodules["bar"].Url)
+}
+
+func Test_Submodule_Repository(t *testing.T) {
+	server, path, ok := SubmoduleRepository("github.com", "rande/pkgmirror.git", "git@gitlab.com:foo/bar.git")
+	assert.True(t, ok)
+	assert.Equal(t, "gitlab.com", server)
+	assert.Equal(t, "foo/bar.git", path)
+
+	server, path, ok = SubmoduleRepository("github.com", "rande/pkgmirror.git", "../gonode.git")
+	assert.True(t, ok)
+	assert.Equal(t, "github.com", server)
+	assert.Equal(t, "rande/gonode.git", path)
+
+	// the .git suffix is optional in the submodule url
+	server, path, ok = SubmoduleRepository("github.com", "rande/pkgmirror.git", "../gonode")
+	assert.True(t, ok)
+	assert.Equal(t, "github.com", server)
+	assert.Equal(t, "rande/gonode.git", path)
+
+	server, path, ok = SubmoduleRepository("github.com", "rande/pkgmirror.git", "https://gitlab.com/foo/bar")
+	assert.True(t, ok)
+	assert.Equal(t, "gitlab.com", server)
+	assert.Equal(t, "foo/bar.git", path)
+
+	_, _, ok = SubmoduleRepository("github.com", "rande/pkgmirror.git", "/local/path")
+	assert.False(t, ok)
+}
+
+func Test_Submodule_Repository_Outside_Mirror(t *testing.T) {
+	for _, url := range []string{"../../../x.git", "./../../../../x.git", "https://github.com/../../x.git", "git@github.com:foo/../../x.git", "../.git"} {
+		_, _, ok := SubmoduleRepository("github.com", "rande/pkgmirror.git", url)
+		assert.False(t, ok, url)
+	}
+}
+
+func Test_Submodules_Malicious_Gitmodules(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "pkgmirror-submodule")
+	defer os.RemoveAll(dir)
+
+	gitmodules := `[submodule "evil"]
+	path = evil
+	url = ../../../../evil.git
+[submodule "safe"]
+	path = safe
+	url = ../safe.git
+`
+
+	run := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir + "/work"
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com", "GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+
+		output, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(output))
+	}
+
+	os.MkdirAll(dir+"/work", 0755)
+	ioutil.WriteFile(dir+"/work/.gitmodules", []byte(gitmodules), 0644)
+
+	run("init")
+	run("update-index", "--add", "--cacheinfo", "160000,9b9cc9573693611badb397b5d01a1e6645704da7,evil")
+	run("update-index", "--add", "--cacheinfo", "160000,9b9cc9573693611badb397b5d01a1e6645704da7,safe")
+	run("add", ".gitmodules")
+	run("commit", "-m", "submodules")
+	run("clone", "--mirror", ".", dir+"/data/example.com/org/parent.git")
+
+	gs := NewGitService()
+	gs.Logger = log.NewEntry(log.New())
+	gs.Config.DataDir = dir + "/data"
+	gs.Config.Server = "example.com"
+	gs.Mirrors = func(server string) *GitService {
+		return gs
+	}
+
+	submodules, err := gs.Submodules("org/parent.git", "HEAD")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(submodules))
+
+	for _, s := range submodules {
+		mirror, repository := gs.submoduleMirror("org/parent.git", s)
+
+		if s.Name == "evil" {
+			assert.Nil(t, mirror)
+		} else {
+			assert.Equal(t, gs, mirror)
+			assert.Equal(t, "org/safe.git", repository)
+		}
+	}
+
+	assert.Equal(t, pkgmirror.ResourceNotFoundError, gs.EnsureRepository("../../evil.git"))
+}
+
+func Test_Submodules_Relative_Url_Fetch(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "pkgmirror-submodule")
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, exec.Command("git", "clone", "--mirror", fixture, dir+"/upstream/org/other.git").Run())
+
+	gs := NewGitService()
+	gs.Logger = log.NewEntry(log.New())
+	gs.Config.DataDir = dir + "/data"
+	gs.Config.Server = "example.com"
+	gs.Config.Clone = dir + "/upstream/{path}"
+	gs.StateChan = make(chan pkgmirror.State, 10)
+	gs.Mirrors = func(server string) *GitService {
+		return gs
+	}
+
+	assert.NoError(t, gs.Init(nil))
+	defer gs.DB.Close()
+
+	mirror, repository := gs.submoduleMirror("org/parent.git", &Submodule{Name: "other", Path: "other", Url: "../other"})
+	assert.Equal(t, gs, mirror)
+	assert.Equal(t, "org/other.git", repository)
+
+	assert.NoError(t, gs.EnsureRepository(repository))
+
+	// the submodule is fetched by the sync like any other repository
+	assert.Contains(t, gs.findRepositories(), "org/other.git")
+	assert.NoError(t, gs.Fetch(repository))
+}
+
+func Test_Archive_Stale_Submodule(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "pkgmirror-submodule")
+	defer os.RemoveAll(dir)
+
+	gitmodules := `[submodule "stale"]
+	path = stale
+	url = ../stale.git
+`
+
+	run := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir + "/work"
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com", "GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+
+		output, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(output))
+	}
+
+	os.MkdirAll(dir+"/work", 0755)
+	ioutil.WriteFile(dir+"/work/.gitmodules", []byte(gitmodules), 0644)
+	ioutil.WriteFile(dir+"/work/README", []byte("parent"), 0644)
+
+	// the commit is not available in the submodule mirror
+	run("init")
+	run("update-index", "--add", "--cacheinfo", "160000,1111111111111111111111111111111111111111,stale")
+	run("add", ".gitmodules", "README")
+	run("commit", "-m", "submodules")
+	run("clone", "--mirror", ".", dir+"/data/example.com/org/parent.git")
+
+	assert.NoError(t, exec.Command("git", "clone", "--mirror", fixture, dir+"/data/example.com/org/stale.git").Run())
+
+	gs := NewGitService()
+	gs.Logger = log.NewEntry(log.New())
+	gs.Config.DataDir = dir + "/data"
+	gs.Config.Server = "example.com"
+	gs.Mirrors = func(server string) *GitService {
+		return gs
+	}
+
+	commit, err := gs.Backend.RevParse(dir+"/data/example.com/org/parent.git", "HEAD")
+	assert.NoError(t, err)
+
+	buf := bytes.NewBuffer([]byte(""))
+
+	assert.NoError(t, gs.writeArchiveWithSubmodules(buf, "org/parent.git", "master", commit, "tar"))
+
+	names := []string{}
+	tr := tar.NewReader(buf)
+	for hdr, err := tr.Next(); err == nil; hdr, err = tr.Next() {
+		names = append(names, hdr.Name)
+	}
+
+	assert.Contains(t, names, "README")
+	assert.True(t, gs.queued["org/stale.git"], "the stale submodule is fetched")
+}
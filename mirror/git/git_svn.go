@@ -0,0 +1,139 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/rande/pkgmirror"
+)
+
+var (
+	// composer's svn references, ie: /tags/1.0.0/@1234 or /trunk/@1234
+	SVN_REFERENCE = regexp.MustCompile(`^/?(trunk|branches/([^/@]+)|tags/([^/@]+))/?@(\d+)$`)
+
+	// git ref of a svn mirror pinned to a svn revision, ie: 1.0.0@r1234
+	SVN_REVISION_REF = regexp.MustCompile(`^(.+)@r(\d+)$`)
+)
+
+// svnRemote returns the url of the svn repository, the mirrored path ends with
+// .git while the svn url does not.
+func (gs *GitService) svnRemote(path string) string {
+	return strings.Replace(gs.Config.Clone, "{path}", strings.TrimSuffix(path, ".git"), -1)
+}
+
+// cloneSvn converts the svn repository into a bare git repository.
+func (gs *GitService) cloneSvn(ctx context.Context, remote, target string) error {
+	if err := gs.runRemoteCommand(ctx, "", "init", "--bare", target); err != nil {
+		return err
+	}
+
+	args := []string{"svn", "init"}
+	if gs.Config.SvnLayout != "none" {
+		args = append(args, "--stdlayout")
+	}
+
+	if err := gs.runRemoteCommand(ctx, target, append(args, remote)...); err != nil {
+		return err
+	}
+
+	if err := gs.runRemoteCommand(ctx, target, "svn", "fetch"); err != nil {
+		return err
+	}
+
+	return gs.updateSvnRefs(target)
+}
+
+// updateSvnRefs exposes the svn branches and tags fetched by git svn as git
+// branches and tags.
+func (gs *GitService) updateSvnRefs(dir string) error {
+	refs, err := gs.Backend.Refs(dir)
+	if err != nil {
+		return err
+	}
+
+	return gs.Backend.UpdateRefs(dir, svnRefUpdates(refs))
+}
+
+// svnRefUpdates maps the refs created by git svn: trunk (or git-svn without
+// the standard layout) to master, the svn tags to tags and the other svn
+// branches to branches.
+func svnRefUpdates(refs map[string]string) map[string]string {
+	updates := map[string]string{}
+
+	for ref, sha := range refs {
+		if !strings.HasPrefix(ref, "refs/remotes/origin/") {
+			continue
+		}
+
+		name := strings.TrimPrefix(ref, "refs/remotes/origin/")
+
+		if strings.Contains(name, "@") {
+			continue // peg revision of a deleted branch
+		}
+
+		target := ""
+		switch {
+		case name == "trunk" || name == "git-svn":
+			target = "refs/heads/master"
+		case strings.HasPrefix(name, "tags/"):
+			target = "refs/tags/" + strings.TrimPrefix(name, "tags/")
+		default:
+			target = "refs/heads/" + name
+		}
+
+		if refs[target] != sha {
+			updates[target] = sha
+		}
+	}
+
+	return updates
+}
+
+// SvnReference converts a composer svn reference to the matching git ref of
+// the svn mirror, pinned to the svn revision. References to a sub folder are
+// not supported as the archive contains the whole branch.
+func SvnReference(reference string) (string, error) {
+	results := SVN_REFERENCE.FindStringSubmatch(reference)
+
+	if len(results) == 0 {
+		return "", pkgmirror.ResourceNotFoundError
+	}
+
+	ref := "master"
+	switch {
+	case len(results[2]) > 0:
+		ref = results[2]
+	case len(results[3]) > 0:
+		ref = results[3]
+	}
+
+	return fmt.Sprintf("%s@r%s", ref, results[4]), nil
+}
+
+// svnFindRev returns the commit of the ref matching the svn revision, or the
+// last commit before the revision if the ref has not been changed in this
+// revision.
+func (gs *GitService) svnFindRev(dir, ref, revision string) (string, error) {
+	cmd := exec.Command(gs.Config.Binary, "svn", "find-rev", "--before", fmt.Sprintf("r%s", revision), ref)
+	cmd.Dir = dir
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	commit := strings.TrimSpace(string(output))
+	if len(commit) == 0 {
+		return "", pkgmirror.ResourceNotFoundError
+	}
+
+	return commit, nil
+}
@@ -0,0 +1,89 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"testing"
+
+	"github.com/rande/pkgmirror"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Svn_Ref_Updates(t *testing.T) {
+	refs := map[string]string{
+		"refs/heads/master":                  "a",
+		"refs/remotes/origin/trunk":          "b",
+		"refs/remotes/origin/feature":        "c",
+		"refs/remotes/origin/feature@12":     "d",
+		"refs/remotes/origin/tags/1.0.0":     "e",
+		"refs/remotes/origin/tags/1.0.1":     "f",
+		"refs/tags/1.0.0":                    "e",
+		"refs/pkgmirror/upstream/tags/1.0.0": "g",
+	}
+
+	assert.Equal(t, map[string]string{
+		"refs/heads/master":  "b",
+		"refs/heads/feature": "c",
+		"refs/tags/1.0.1":    "f",
+	}, svnRefUpdates(refs))
+
+	assert.Equal(t, map[string]string{
+		"refs/heads/master": "h",
+	}, svnRefUpdates(map[string]string{"refs/remotes/origin/git-svn": "h"}))
+}
+
+func Test_Svn_Reference(t *testing.T) {
+	values := []*Expectation{
+		{"master@r1234", "/trunk/@1234"},
+		{"master@r1234", "trunk/@1234"},
+		{"master@r1234", "trunk@1234"},
+		{"1.0.0@r1234", "/tags/1.0.0/@1234"},
+		{"feature@r42", "/branches/feature/@42"},
+	}
+
+	for _, v := range values {
+		ref, err := SvnReference(v.Value)
+
+		assert.NoError(t, err)
+		assert.Equal(t, v.Expected, ref)
+		assert.True(t, SVN_REVISION_REF.MatchString(ref), "the ref is resolved with git svn find-rev")
+	}
+
+	for _, reference := range []string{"9b9cc9573693611badb397b5d01a1e6645704da7", "/tags/1.0.0/src/@1234", "/trunk/src/@1234", "/tags/1.0.0/"} {
+		_, err := SvnReference(reference)
+		assert.Equal(t, pkgmirror.ResourceNotFoundError, err, reference)
+	}
+}
+
+func Test_Svn_Rewrite(t *testing.T) {
+	publicServer := "https://mirrors.localhost"
+
+	rewriter, err := NewRewriterFromConfig(&pkgmirror.Config{
+		Git: map[string]*pkgmirror.GitConfig{
+			"svn": {
+				Server:  "svn.example.com",
+				Enabled: true,
+				Type:    "svn",
+				Clone:   "https://svn.example.com/repos/{path}",
+			},
+		},
+	})
+
+	assert.NoError(t, err)
+
+	assert.Equal(t, "https://mirrors.localhost/git/svn.example.com/project.git", rewriter.Repository(publicServer, "https://svn.example.com/repos/project"))
+	assert.Equal(t, "svn://localhost/path/to/project", rewriter.Repository(publicServer, "svn://localhost/path/to/project"))
+
+	repository, ref, archive, ok := rewriter.Svn(publicServer, "https://svn.example.com/repos/project/", "/tags/1.0.0/@1234")
+
+	assert.True(t, ok)
+	assert.Equal(t, "https://mirrors.localhost/git/svn.example.com/project.git", repository)
+	assert.Equal(t, "1.0.0@r1234", ref)
+	assert.Equal(t, "https://mirrors.localhost/git/svn.example.com/project/1.0.0@r1234.zip", archive)
+
+	_, _, _, ok = rewriter.Svn(publicServer, "https://svn.other.com/repos/project", "/tags/1.0.0/@1234")
+	assert.False(t, ok)
+}
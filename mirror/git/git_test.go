@@ -6,8 +6,13 @@
 package git
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 
+	log "github.com/Sirupsen/logrus"
+	"github.com/rande/pkgmirror"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -73,3 +78,57 @@ func Test_Repository_Rewrite_SVN(t *testing.T) {
 		assert.Equal(t, v.Expected, GitRewriteRepository(publicServer, v.Value))
 	}
 }
+
+func Test_Clone_Allow_Deny(t *testing.T) {
+	gs := NewGitService()
+
+	assert.True(t, gs.CanClone("rande/pkgmirror.git"))
+
+	gs.Config.CloneAllow = []string{"rande/*.git", "symfony/*.git"}
+	gs.Config.CloneDeny = []string{"symfony/symfony.git"}
+
+	assert.True(t, gs.CanClone("rande/pkgmirror.git"))
+	assert.True(t, gs.CanClone("symfony/console.git"))
+	assert.False(t, gs.CanClone("symfony/symfony.git"))
+	assert.False(t, gs.CanClone("foo/bar.git"))
+	assert.False(t, gs.CanClone("rande/sub/project.git"))
+}
+
+func Test_Clone_Rate_Limit(t *testing.T) {
+	gs := NewGitService()
+	now := time.Now()
+
+	assert.True(t, gs.AllowClient("127.0.0.1", now))
+
+	gs.Config.CloneRateLimit = 2
+
+	assert.True(t, gs.AllowClient("127.0.0.1", now))
+	assert.True(t, gs.AllowClient("127.0.0.1", now))
+	assert.False(t, gs.AllowClient("127.0.0.1", now))
+	assert.True(t, gs.AllowClient("127.0.0.2", now))
+	assert.True(t, gs.AllowClient("127.0.0.1", now.Add(time.Hour)))
+}
+
+func Test_Prepare_Repository_Denied_Clone(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "pkgmirror-prepare")
+	defer os.RemoveAll(dir)
+
+	gs := NewGitService()
+	gs.Logger = log.NewEntry(log.New())
+	gs.Config.DataDir = dir + "/data"
+	gs.Config.Server = "example.com"
+	gs.Config.Clone = "https://github.com/{path}"
+	gs.Config.CloneAllow = []string{"rande/*.git"}
+	gs.Config.CloneRateLimit = 1
+	gs.StateChan = make(chan pkgmirror.State, 10)
+
+	assert.NoError(t, gs.Init(nil))
+	defer gs.DB.Close()
+
+	assert.Equal(t, pkgmirror.CloneForbiddenError, gs.PrepareRepository("symfony/symfony.git", "127.0.0.1"))
+	assert.Equal(t, pkgmirror.ResourceNotFoundError, gs.PrepareRepository("../foo.git", "127.0.0.1"))
+
+	// the rejected requests did not use the client's budget
+	assert.True(t, gs.AllowClient("127.0.0.1", time.Now()))
+	assert.False(t, gs.AllowClient("127.0.0.1", time.Now()))
+}
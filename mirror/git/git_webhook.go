@@ -0,0 +1,90 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"net/http"
+	"strings"
+
+	"github.com/rande/pkgmirror"
+)
+
+var WebhookProviders = []string{"github", "gitlab", "gitea"}
+
+type webhookPayload struct {
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+}
+
+// ValidateWebhook checks the request has been signed with the shared secret,
+// following each provider's convention.
+func ValidateWebhook(provider, secret string, r *http.Request, body []byte) bool {
+	if len(secret) == 0 {
+		return false
+	}
+
+	switch provider {
+	case "github":
+		if signature := r.Header.Get("X-Hub-Signature-256"); len(signature) > 0 {
+			return validSignature(sha256.New, secret, strings.TrimPrefix(signature, "sha256="), body)
+		}
+
+		return validSignature(sha1.New, secret, strings.TrimPrefix(r.Header.Get("X-Hub-Signature"), "sha1="), body)
+	case "gitea":
+		return validSignature(sha256.New, secret, r.Header.Get("X-Gitea-Signature"), body)
+	case "gitlab":
+		return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(secret)) == 1
+	}
+
+	return false
+}
+
+func validSignature(h func() hash.Hash, secret, signature string, body []byte) bool {
+	expected, err := hex.DecodeString(signature)
+
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// WebhookRepositoryPath returns the local repository path (ie, rande/pkgmirror.git)
+// referenced by the webhook payload.
+func WebhookRepositoryPath(provider string, body []byte) (string, error) {
+	p := &webhookPayload{}
+
+	if err := json.Unmarshal(body, p); err != nil {
+		return "", err
+	}
+
+	name := p.Repository.FullName
+	if provider == "gitlab" {
+		name = p.Project.PathWithNamespace
+	}
+
+	name = strings.Trim(name, "/")
+
+	if len(name) == 0 || strings.Contains(name, "..") {
+		return "", pkgmirror.InvalidPackageError
+	}
+
+	return name + ".git", nil
+}
@@ -0,0 +1,71 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package git
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Webhook_Validate_Github(t *testing.T) {
+	body := []byte(`{"repository": {"full_name": "rande/pkgmirror"}}`)
+
+	r, _ := http.NewRequest("POST", "/git/github.com/hooks/github", nil)
+	r.Header.Set("X-Hub-Signature-256", "sha256=2eb4df5a6a5f0fd8a6c39bdbf8d9f66fc8cbc80a6d0c1e1ec7bde3f5b0cf6d35")
+	assert.False(t, ValidateWebhook("github", "secret", r, body))
+
+	r.Header.Set("X-Hub-Signature-256", "sha256="+sign("secret", body))
+	assert.True(t, ValidateWebhook("github", "secret", r, body))
+	assert.False(t, ValidateWebhook("github", "other", r, body))
+	assert.False(t, ValidateWebhook("github", "", r, body))
+}
+
+func Test_Webhook_Validate_Gitea(t *testing.T) {
+	body := []byte(`{"repository": {"full_name": "rande/pkgmirror"}}`)
+
+	r, _ := http.NewRequest("POST", "/git/gitea.example.com/hooks/gitea", nil)
+	r.Header.Set("X-Gitea-Signature", sign("secret", body))
+
+	assert.True(t, ValidateWebhook("gitea", "secret", r, body))
+	assert.False(t, ValidateWebhook("gitea", "secret", r, []byte("{}")))
+}
+
+func Test_Webhook_Validate_Gitlab(t *testing.T) {
+	r, _ := http.NewRequest("POST", "/git/gitlab.com/hooks/gitlab", nil)
+	r.Header.Set("X-Gitlab-Token", "secret")
+
+	assert.True(t, ValidateWebhook("gitlab", "secret", r, nil))
+	assert.False(t, ValidateWebhook("gitlab", "other", r, nil))
+	assert.False(t, ValidateWebhook("bitbucket", "secret", r, nil))
+}
+
+func Test_Webhook_Repository_Path(t *testing.T) {
+	path, err := WebhookRepositoryPath("github", []byte(`{"repository": {"full_name": "rande/pkgmirror"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "rande/pkgmirror.git", path)
+
+	path, err = WebhookRepositoryPath("gitlab", []byte(`{"project": {"path_with_namespace": "group/sub/project"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "group/sub/project.git", path)
+
+	_, err = WebhookRepositoryPath("gitea", []byte(`{"repository": {"full_name": "../etc"}}`))
+	assert.Error(t, err)
+
+	_, err = WebhookRepositoryPath("gitea", []byte(`{}`))
+	assert.Error(t, err)
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
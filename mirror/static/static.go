@@ -35,6 +35,7 @@ func NewStaticService() *StaticService {
 				Root: "./cache/git",
 			},
 		},
+		downloads: map[string]bool{},
 	}
 }
 
@@ -50,6 +51,8 @@ type StaticService struct {
 	Logger    *log.Entry
 	Vault     *vault.Vault
 	StateChan chan pkgmirror.State
+	downloads map[string]bool
+	lock      sync.Mutex
 }
 
 func (gs *StaticService) Init(app *goapp.App) (err error) {
@@ -73,7 +76,11 @@ func (gs *StaticService) Serve(state *goapp.GoroutineState) error {
 	return nil
 }
 
-func (gs *StaticService) WriteArchive(w io.Writer, path string) (*StaticFile, error) {
+// WriteArchive writes the file to w, ready is called with the file information
+// before the first byte is written. A missing file is streamed from the source
+// server while it is written into the vault, the entry is only committed once
+// the download completes.
+func (gs *StaticService) WriteArchive(w io.Writer, path string, ready func(file *StaticFile)) (*StaticFile, error) {
 	vaultKey := fmt.Sprintf("%s", path)
 	bucketKey := vaultKey
 	url := fmt.Sprintf("%s/%s", gs.Config.SourceServer, path)
@@ -104,80 +111,127 @@ func (gs *StaticService) WriteArchive(w io.Writer, path string) (*StaticFile, er
 		return nil
 	})
 
-	if err == pkgmirror.EmptyDataError {
-		file.Url = url
-	} else if err != nil {
+	if err != nil && err != pkgmirror.EmptyDataError {
 		return nil, err
 	}
 
-	if !gs.Vault.Has(vaultKey) {
-		logger.Info("Create vault entry")
+	// the entry is committed once the bucket key is saved
+	if err == nil && gs.Vault.Has(vaultKey) {
+		logger.Info("Read vault entry")
 
-		var wg sync.WaitGroup
-		var err error
-		var data []byte
+		ready(file)
 
-		pr, pw := io.Pipe()
-		wg.Add(1)
+		if _, err := gs.Vault.Get(vaultKey, w); err != nil {
+			return nil, err
+		}
 
-		go func() {
-			meta := vault.NewVaultMetadata()
-			meta["path"] = path
+		return file, nil
+	}
 
-			if _, err := gs.Vault.Put(vaultKey, meta, pr); err != nil {
-				logger.WithError(err).Info("Error while writing into vault")
+	file.Url = url
 
-				gs.Vault.Remove(vaultKey)
-			}
+	gs.lock.Lock()
+	if gs.downloads[vaultKey] {
+		gs.lock.Unlock()
 
-			wg.Done()
-		}()
+		logger.Info("Vault entry in progress, stream the remote file")
 
-		if err = gs.downloadStatic(pw, file); err != nil {
-			logger.WithError(err).Info("Error while writing archive")
+		return file, gs.downloadStatic(w, file, ready)
+	}
+	gs.downloads[vaultKey] = true
+	gs.lock.Unlock()
 
-			pw.Close()
-			pr.Close()
+	defer func() {
+		gs.lock.Lock()
+		delete(gs.downloads, vaultKey)
+		gs.lock.Unlock()
+	}()
 
-			gs.Vault.Remove(vaultKey)
+	logger.Info("Create vault entry")
 
-			return nil, err
-		} else {
-			pw.Close()
+	pr, pw := io.Pipe()
+	done := make(chan error)
+
+	go func() {
+		meta := vault.NewVaultMetadata()
+		meta["path"] = path
+
+		_, err := gs.Vault.Put(vaultKey, meta, pr)
+
+		pr.CloseWithError(err) // the next writes fail if the vault fails, the client download goes on
+
+		done <- err
+	}()
+
+	tee := &teeWriter{vault: pw, client: w}
+
+	if err := gs.downloadStatic(tee, file, ready); err != nil {
+		logger.WithError(err).Info("Error while downloading the file")
+
+		pw.CloseWithError(err)
+		<-done
+
+		gs.Vault.Remove(vaultKey)
+
+		return nil, err
+	}
+
+	pw.Close()
+
+	if err := <-done; err != nil || tee.vaultErr != nil {
+		if err == nil {
+			err = tee.vaultErr
 		}
 
-		wg.Wait()
+		logger.WithError(err).Info("Error while writing into vault")
 
-		pr.Close()
+		gs.Vault.Remove(vaultKey)
 
-		err = gs.DB.Update(func(tx *bolt.Tx) error {
-			b := tx.Bucket(gs.Config.Code)
+		return file, tee.clientErr // the client got the file
+	}
 
-			data, err = json.Marshal(file)
+	err = gs.DB.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(file)
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(gs.Config.Code).Put([]byte(bucketKey), data)
+	})
+
+	if err != nil {
+		logger.WithError(err).Error("Unable to commit the vault entry")
+	}
+
+	return file, tee.clientErr
+}
 
-			if err != nil {
-				return err
-			}
+// teeWriter writes the download into the vault and to the client, an error on
+// one side stops the writes to this side only. The download stops if both fail.
+type teeWriter struct {
+	vault     io.Writer
+	client    io.Writer
+	vaultErr  error
+	clientErr error
+}
 
-			if err := b.Put([]byte(bucketKey), data); err != nil {
-				return err
-			}
+func (t *teeWriter) Write(p []byte) (int, error) {
+	if t.clientErr == nil {
+		_, t.clientErr = t.client.Write(p)
+	}
 
-			return nil
-		})
-	} else {
-		logger.Info("Vault entry exist!")
+	if t.vaultErr == nil {
+		_, t.vaultErr = t.vault.Write(p)
 	}
 
-	logger.Info("Read vault entry")
-	if _, err := gs.Vault.Get(vaultKey, w); err != nil {
-		return nil, err
+	if t.clientErr != nil && t.vaultErr != nil {
+		return 0, t.clientErr
 	}
 
-	return file, nil
+	return len(p), nil
 }
 
-func (gs *StaticService) downloadStatic(w io.Writer, file *StaticFile) error {
+func (gs *StaticService) downloadStatic(w io.Writer, file *StaticFile, ready func(file *StaticFile)) error {
 	logger := gs.Logger.WithFields(log.Fields{
 		"url":    file.Url,
 		"action": "writeArchive",
@@ -201,6 +255,11 @@ func (gs *StaticService) downloadStatic(w io.Writer, file *StaticFile) error {
 		return pkgmirror.HttpError
 	}
 
+	file.Header = resp.Header
+	file.DownloadAt = time.Now()
+
+	ready(file)
+
 	written, err := io.Copy(w, resp.Body)
 
 	if err != nil {
@@ -210,8 +269,6 @@ func (gs *StaticService) downloadStatic(w io.Writer, file *StaticFile) error {
 	}
 
 	file.Size = written
-	file.Header = resp.Header
-	file.DownloadAt = time.Now()
 
 	logger.Info("Complete downloading the remote static file")
 
@@ -6,7 +6,6 @@
 package static
 
 import (
-	"bytes"
 	"fmt"
 	"net/http"
 
@@ -97,18 +96,9 @@ func ConfigureHttp(name string, conf *pkgmirror.StaticConfig, app *goapp.App) {
 	mux.HandleFuncC(pat.Get(fmt.Sprintf("/static/%s/*", name)), func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		path := r.URL.Path[9+len(name):]
 
-		// this will consumes too much memory with large files.
-		buf := bytes.NewBuffer([]byte(""))
-
-		if file, err := staticService.WriteArchive(buf, path); err != nil {
-			code := 500
-			if err == pkgmirror.ResourceNotFoundError {
-				code = 404
-			}
-
-			pkgmirror.SendWithHttpCode(w, code, err.Error())
-		} else {
+		sent := false
 
+		_, err := staticService.WriteArchive(w, path, func(file *StaticFile) {
 			// copy header
 			for name := range file.Header {
 				if name == "Content-Length" {
@@ -120,7 +110,23 @@ func ConfigureHttp(name string, conf *pkgmirror.StaticConfig, app *goapp.App) {
 
 			w.WriteHeader(200)
 
-			buf.WriteTo(w)
+			sent = true
+		})
+
+		if err != nil && sent {
+			staticService.Logger.WithError(err).WithField("path", path).Error("Error while streaming the file")
+
+			// the status is sent, abort the connection so the client sees a failed download
+			panic(http.ErrAbortHandler)
+		}
+
+		if err != nil {
+			code := 500
+			if err == pkgmirror.ResourceNotFoundError {
+				code = 404
+			}
+
+			pkgmirror.SendWithHttpCode(w, code, err.Error())
 		}
 	})
 }
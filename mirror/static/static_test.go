@@ -0,0 +1,131 @@
+// Copyright © 2016-present Thomas Rabaix <thomas.rabaix@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package static
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rande/gonode/core/vault"
+	"github.com/rande/pkgmirror"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestStaticService(t *testing.T, server string) (*StaticService, func()) {
+	dir, _ := ioutil.TempDir("", "pkgmirror-static")
+
+	gs := NewStaticService()
+	gs.Config.Path = dir + "/data"
+	gs.Config.Code = []byte("static")
+	gs.Config.SourceServer = server
+	gs.Logger = log.NewEntry(log.New())
+	gs.Vault = &vault.Vault{
+		Algo: "no_op",
+		Driver: &vault.DriverFs{
+			Root: dir + "/cache",
+		},
+	}
+
+	assert.NoError(t, gs.Init(nil))
+
+	return gs, func() {
+		gs.DB.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_WriteArchive(t *testing.T) {
+	hits := 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+
+		switch r.URL.Path {
+		case "/file.txt":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("This is a sample test file."))
+
+		case "/broken.txt":
+			// the connection is closed before the announced length
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte("This is a broken"))
+
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	gs, clean := newTestStaticService(t, ts.URL)
+	defer clean()
+
+	for i := 0; i < 2; i++ {
+		buf := bytes.NewBuffer([]byte(""))
+		ready := false
+
+		file, err := gs.WriteArchive(buf, "file.txt", func(file *StaticFile) {
+			assert.Equal(t, 0, buf.Len(), "ready is called before writing")
+
+			ready = true
+		})
+
+		assert.NoError(t, err)
+		assert.True(t, ready)
+		assert.Equal(t, "This is a sample test file.", buf.String())
+		assert.Equal(t, int64(27), file.Size)
+		assert.Equal(t, "text/plain", file.Header.Get("Content-Type"))
+	}
+
+	assert.Equal(t, 1, hits, "the second call reads the vault")
+
+	_, err := gs.WriteArchive(bytes.NewBuffer([]byte("")), "missing.txt", func(file *StaticFile) {})
+	assert.Equal(t, pkgmirror.ResourceNotFoundError, err)
+
+	_, err = gs.WriteArchive(bytes.NewBuffer([]byte("")), "broken.txt", func(file *StaticFile) {})
+	assert.Error(t, err)
+	assert.False(t, gs.Vault.Has("broken.txt"), "an incomplete download is not committed")
+
+	hits = 0
+
+	_, err = gs.WriteArchive(bytes.NewBuffer([]byte("")), "broken.txt", func(file *StaticFile) {})
+	assert.Error(t, err)
+	assert.Equal(t, 1, hits)
+}
+
+func Test_WriteArchive_Vault_Error(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), 1024*1024))
+	}))
+	defer ts.Close()
+
+	gs, clean := newTestStaticService(t, ts.URL)
+	defer clean()
+
+	// the vault cannot write under a regular file
+	root, _ := ioutil.TempFile("", "pkgmirror-static-vault")
+	root.Close()
+	defer os.Remove(root.Name())
+
+	gs.Vault = &vault.Vault{
+		Algo: "no_op",
+		Driver: &vault.DriverFs{
+			Root: root.Name(),
+		},
+	}
+
+	buf := bytes.NewBuffer([]byte(""))
+
+	file, err := gs.WriteArchive(buf, "file.bin", func(file *StaticFile) {})
+	assert.NoError(t, err)
+	assert.Equal(t, 1024*1024, buf.Len(), "the client gets the whole file")
+	assert.Equal(t, int64(1024*1024), file.Size)
+	assert.False(t, gs.Vault.Has("file.bin"))
+}
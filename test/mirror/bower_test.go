@@ -8,6 +8,7 @@ package mirror
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"testing"
 	"time"
 
@@ -58,3 +59,69 @@ func Test_Bower_Get_Packages(t *testing.T) {
 		assert.Equal(t, 3, len(v))
 	})
 }
+
+func Test_Bower_Search_Packages(t *testing.T) {
+	optin := &test.TestOptin{Bower: true}
+
+	test.RunHttpTest(t, optin, func(args *test.Arguments) {
+		// wait for the synchro to complete
+		time.Sleep(1 * time.Second)
+
+		res, err := test.RunRequest("GET", fmt.Sprintf("%s/bower/bower/packages/search/legal", args.TestServer.URL))
+		assert.NoError(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+
+		v := make(bower.Packages, 0)
+		err = json.Unmarshal(res.GetBody(), &v)
+
+		assert.Equal(t, 1, len(v))
+		assert.Equal(t, "10digit-legal", v[0].Name)
+
+		res, err = test.RunRequest("GET", fmt.Sprintf("%s/bower/bower/packages/search/10digit", args.TestServer.URL))
+		assert.NoError(t, err)
+
+		v = make(bower.Packages, 0)
+		err = json.Unmarshal(res.GetBody(), &v)
+
+		assert.Equal(t, 3, len(v))
+	})
+}
+
+func Test_Bower_Register_Package(t *testing.T) {
+	optin := &test.TestOptin{Bower: true}
+
+	test.RunHttpTest(t, optin, func(args *test.Arguments) {
+		// wait for the synchro to complete
+		time.Sleep(1 * time.Second)
+
+		res, err := test.RunRequest("POST", fmt.Sprintf("%s/bower/bower/packages", args.TestServer.URL), url.Values{
+			"name": {"private-legal"},
+			"url":  {"https://git.example.com/private/legal.git"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 201, res.StatusCode)
+
+		res, err = test.RunRequest("POST", fmt.Sprintf("%s/bower/bower/packages", args.TestServer.URL), url.Values{
+			"name": {"10digit-legal"},
+			"url":  {"https://git.example.com/private/legal.git"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 403, res.StatusCode)
+
+		res, err = test.RunRequest("GET", fmt.Sprintf("%s/bower/bower/packages", args.TestServer.URL))
+		assert.NoError(t, err)
+
+		v := make(bower.Packages, 0)
+		err = json.Unmarshal(res.GetBody(), &v)
+
+		assert.Equal(t, 4, len(v))
+
+		res, err = test.RunRequest("DELETE", fmt.Sprintf("%s/bower/bower/packages/private-legal", args.TestServer.URL))
+		assert.NoError(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+
+		res, err = test.RunRequest("GET", fmt.Sprintf("%s/bower/bower/packages/private-legal", args.TestServer.URL))
+		assert.NoError(t, err)
+		assert.Equal(t, 404, res.StatusCode)
+	})
+}
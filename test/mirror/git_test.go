@@ -6,11 +6,14 @@
 package mirror
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
+	"sync"
 	"testing"
 
+	"github.com/rande/pkgmirror/api"
 	"github.com/rande/pkgmirror/mirror/git"
 	"github.com/rande/pkgmirror/test"
 	"github.com/stretchr/testify/assert"
@@ -53,6 +56,44 @@ func Test_Git_Clone_Non_Existing_Repo(t *testing.T) {
 	})
 }
 
+func Test_Git_Clone_Concurrent_Requests(t *testing.T) {
+	optin := &test.TestOptin{Git: true}
+
+	test.RunHttpTest(t, optin, func(args *test.Arguments) {
+		gitService := args.App.Get("pkgmirror.git.local").(*git.GitService)
+
+		assert.False(t, gitService.Has("foobar.git"))
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				assert.NoError(t, gitService.EnsureRepository("foobar.git"))
+			}()
+		}
+
+		wg.Wait()
+
+		assert.True(t, gitService.Has("foobar.git"))
+	})
+}
+
+func Test_Git_Clone_Invalid_Remote(t *testing.T) {
+	optin := &test.TestOptin{Git: true}
+
+	test.RunHttpTest(t, optin, func(args *test.Arguments) {
+		gitService := args.App.Get("pkgmirror.git.local").(*git.GitService)
+
+		assert.Error(t, gitService.EnsureRepository("non-existant.git"))
+		assert.False(t, gitService.Has("non-existant.git"))
+		assert.False(t, gitService.Has("non-existant.git.pkgmirror-clone"))
+	})
+}
+
 func Test_Git_Has(t *testing.T) {
 	optin := &test.TestOptin{Git: true}
 
@@ -98,13 +139,106 @@ func Test_Git_Download_Sha1_Archive(t *testing.T) {
 	})
 }
 
+func Test_Git_Download_Tarball_Archive(t *testing.T) {
+	optin := &test.TestOptin{Git: true}
+
+	test.RunHttpTest(t, optin, func(args *test.Arguments) {
+		res, _ := test.RunRequest("GET", fmt.Sprintf("%s/git/local/foo/0.0.1.tar.gz", args.TestServer.URL))
+
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "application/x-gzip", res.Header.Get("Content-Type"))
+
+		res, _ = test.RunRequest("GET", fmt.Sprintf("%s/git/local/foo/master.tgz", args.TestServer.URL))
+
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "application/x-gzip", res.Header.Get("Content-Type"))
+
+		res, _ = test.RunRequest("GET", fmt.Sprintf("%s/git/local/foo/master.tar", args.TestServer.URL))
+
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "application/x-tar", res.Header.Get("Content-Type"))
+	})
+}
+
+func Test_Git_Download_Archive_ETag(t *testing.T) {
+	optin := &test.TestOptin{Git: true}
+
+	test.RunHttpTest(t, optin, func(args *test.Arguments) {
+		res, _ := test.RunRequest("GET", fmt.Sprintf("%s/git/local/foo/master.zip", args.TestServer.URL))
+
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, `"9b9cc9573693611badb397b5d01a1e6645704da7"`, res.Header.Get("ETag"))
+
+		res, _ = test.RunRequest("GET", fmt.Sprintf("%s/git/local/foo/master.zip", args.TestServer.URL), nil, map[string]string{
+			"If-None-Match": `"9b9cc9573693611badb397b5d01a1e6645704da7"`,
+		})
+
+		assert.Equal(t, 304, res.StatusCode)
+	})
+}
+
 func Test_Git_Download_Non_Existant_Archive(t *testing.T) {
 	optin := &test.TestOptin{Git: true}
 
 	test.RunHttpTest(t, optin, func(args *test.Arguments) {
 		res, _ := test.RunRequest("GET", fmt.Sprintf("%s/git/local/bar/master.zip", args.TestServer.URL))
 
-		assert.Equal(t, 500, res.StatusCode)
+		assert.Equal(t, 404, res.StatusCode)
 		assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+
+		res, _ = test.RunRequest("GET", fmt.Sprintf("%s/git/local/foo/unknown.zip", args.TestServer.URL))
+
+		assert.Equal(t, 404, res.StatusCode)
+		assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	})
+}
+
+func Test_Git_Api_Repositories(t *testing.T) {
+	optin := &test.TestOptin{Git: true}
+
+	test.RunHttpTest(t, optin, func(args *test.Arguments) {
+		res, _ := test.RunRequest("GET", fmt.Sprintf("%s/api/git/local/repositories?q=foo", args.TestServer.URL))
+
+		assert.Equal(t, 200, res.StatusCode)
+
+		list := &api.GitRepositoryList{}
+		assert.NoError(t, json.Unmarshal(res.GetBody(), list))
+
+		assert.Equal(t, 1, list.Total)
+		assert.Equal(t, "foo.git", list.Repositories[0].Path)
+		assert.Equal(t, "master", list.Repositories[0].DefaultBranch)
+		assert.True(t, list.Repositories[0].Size > 0)
+
+		res, _ = test.RunRequest("GET", fmt.Sprintf("%s/api/git/local/repositories?q=bar", args.TestServer.URL))
+
+		list = &api.GitRepositoryList{}
+		assert.NoError(t, json.Unmarshal(res.GetBody(), list))
+
+		assert.Equal(t, 0, list.Total)
+
+		res, _ = test.RunRequest("GET", fmt.Sprintf("%s/api/git/local/repositories/foo.git/refs", args.TestServer.URL))
+
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "[]\n", string(res.GetBody()))
+
+		res, _ = test.RunRequest("POST", fmt.Sprintf("%s/api/git/local/repositories/foo.git/fetch", args.TestServer.URL))
+
+		assert.Equal(t, 403, res.StatusCode, "the token is required")
+
+		res, _ = test.RunRequest("POST", fmt.Sprintf("%s/api/git/local/repositories/foo.git/fetch?access_token=secret", args.TestServer.URL))
+
+		assert.Equal(t, 202, res.StatusCode)
+
+		res, _ = test.RunRequest("DELETE", fmt.Sprintf("%s/api/git/local/repositories/foo.git", args.TestServer.URL))
+
+		assert.Equal(t, 403, res.StatusCode, "the token is required")
+
+		res, _ = test.RunRequest("DELETE", fmt.Sprintf("%s/api/git/local/repositories/foo.git?access_token=invalid", args.TestServer.URL))
+
+		assert.Equal(t, 403, res.StatusCode)
+
+		res, _ = test.RunRequest("DELETE", fmt.Sprintf("%s/api/git/local/repositories/bar.git?access_token=secret", args.TestServer.URL))
+
+		assert.Equal(t, 404, res.StatusCode)
 	})
 }
@@ -201,10 +201,11 @@ func RunHttpTest(t *testing.T, optin *TestOptin, f func(args *Arguments)) {
 		LogLevel:       "debug",
 		Git: map[string]*pkgmirror.GitConfig{
 			"local": {
-				Server:  "local",
-				Enabled: optin.Git,
-				Icon:    "https://assets-cdn.github.com/images/modules/logos_page/GitHub-Mark.png",
-				Clone:   fmt.Sprintf("file://%s/data/git/source/{path}", baseFolder),
+				Server:   "local",
+				Enabled:  optin.Git,
+				Icon:     "https://assets-cdn.github.com/images/modules/logos_page/GitHub-Mark.png",
+				Clone:    fmt.Sprintf("file://%s/data/git/source/{path}", baseFolder),
+				ApiToken: "secret",
 			},
 		},
 		Npm: map[string]*pkgmirror.NpmConfig{
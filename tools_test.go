@@ -6,6 +6,7 @@
 package pkgmirror
 
 import (
+	"net/http/httptest"
 	"sync/atomic"
 	"testing"
 
@@ -93,3 +94,17 @@ func Test_WorkerManager_Result(t *testing.T) {
 
 	assert.Equal(t, int32(15), cpt)
 }
+
+func Test_RequestToken(t *testing.T) {
+	r := httptest.NewRequest("DELETE", "/bower/bower/packages/foo", nil)
+	assert.Equal(t, "", RequestToken(r))
+
+	r = httptest.NewRequest("DELETE", "/bower/bower/packages/foo?access_token=query", nil)
+	assert.Equal(t, "query", RequestToken(r))
+
+	r.SetBasicAuth("bower", "basic")
+	assert.Equal(t, "basic", RequestToken(r))
+
+	r.Header.Set("Authorization", "Bearer bearer")
+	assert.Equal(t, "bearer", RequestToken(r))
+}